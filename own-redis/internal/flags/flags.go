@@ -10,9 +10,23 @@ import (
 
 const DefaultPort = 8080
 
-func FlagInit() int {
+type Config struct {
+	Port           int
+	TLSPort        int
+	TLSCertFile    string
+	TLSKeyFile     string
+	TLSCACertFile  string
+	TLSAuthClients string
+}
+
+func FlagInit() Config {
 	help := flag.Bool("help", false, "Show help message")
 	port := flag.Int("port", DefaultPort, "Port number")
+	tlsPort := flag.Int("tls-port", 0, "TLS port number, 0 disables TLS")
+	tlsCertFile := flag.String("tls-cert-file", "", "Server certificate file")
+	tlsKeyFile := flag.String("tls-key-file", "", "Server private key file")
+	tlsCACertFile := flag.String("tls-ca-cert-file", "", "CA certificate used to verify clients")
+	tlsAuthClients := flag.String("tls-auth-clients", "", "Client certificate verification: yes, no or optional")
 
 	flag.Usage = utils.Usage
 	flag.Parse()
@@ -27,5 +41,44 @@ func FlagInit() int {
 		os.Exit(1)
 	}
 
-	return *port
+	if *tlsPort != 0 {
+		if *tlsPort < 1 || *tlsPort > 65535 {
+			fmt.Fprintf(os.Stderr, "Error: TLS port number %d is out of valid range\n", *tlsPort)
+			os.Exit(1)
+		}
+		if *tlsCertFile == "" || *tlsKeyFile == "" {
+			fmt.Fprintln(os.Stderr, "Error: --tls-cert-file and --tls-key-file are required with --tls-port")
+			os.Exit(1)
+		}
+	}
+
+	// Client certificates are required by default only when there is a CA
+	// to verify them against.
+	if *tlsAuthClients == "" {
+		*tlsAuthClients = "no"
+		if *tlsCACertFile != "" {
+			*tlsAuthClients = "yes"
+		}
+	}
+
+	switch *tlsAuthClients {
+	case "yes", "no", "optional":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --tls-auth-clients value %q\n", *tlsAuthClients)
+		os.Exit(1)
+	}
+
+	if *tlsAuthClients != "no" && *tlsCACertFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: --tls-auth-clients %s requires --tls-ca-cert-file\n", *tlsAuthClients)
+		os.Exit(1)
+	}
+
+	return Config{
+		Port:           *port,
+		TLSPort:        *tlsPort,
+		TLSCertFile:    *tlsCertFile,
+		TLSKeyFile:     *tlsKeyFile,
+		TLSCACertFile:  *tlsCACertFile,
+		TLSAuthClients: *tlsAuthClients,
+	}
 }
@@ -0,0 +1,524 @@
+package server
+
+import (
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const (
+	bitOffsetError = "(error) ERR bit offset is not an integer or out of range"
+	bitValueError  = "(error) ERR bit is not an integer or out of range"
+	bitTypeError   = "(error) ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."
+	integerError   = "(error) ERR value is not an integer or out of range"
+
+	maxBitOffset = 1<<32 - 1
+)
+
+// stringBytes returns a copy of the string stored under key. The caller must
+// hold s.mu for writing.
+func (s *Server) stringBytes(key string) (data []byte, entry valueEntry, errResp string) {
+	entry, ok := s.lookup(key)
+	if !ok {
+		return nil, valueEntry{}, ""
+	}
+	value, isString := entry.Value.(string)
+	if !isString {
+		return nil, entry, wrongTypeError
+	}
+	return []byte(value), entry, ""
+}
+
+func parseBitOffset(arg string) (uint64, bool) {
+	offset, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || offset > maxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func getBit(data []byte, offset uint64) int {
+	byteIndex := offset / 8
+	if byteIndex >= uint64(len(data)) {
+		return 0
+	}
+	return int(data[byteIndex]>>(7-offset%8)) & 1
+}
+
+func setBit(data []byte, offset uint64, bit int) []byte {
+	byteIndex := offset / 8
+	if byteIndex >= uint64(len(data)) {
+		data = append(data, make([]byte, byteIndex+1-uint64(len(data)))...)
+	}
+	mask := byte(1 << (7 - offset%8))
+	if bit == 1 {
+		data[byteIndex] |= mask
+	} else {
+		data[byteIndex] &^= mask
+	}
+	return data
+}
+
+func (s *Server) handleSetBit(args []string) string {
+	if len(args) != 3 {
+		return wrongArgs("SETBIT")
+	}
+
+	offset, ok := parseBitOffset(args[1])
+	if !ok {
+		return bitOffsetError
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return bitValueError
+	}
+	bit := int(args[2][0] - '0')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, entry, errResp := s.stringBytes(args[0])
+	if errResp != "" {
+		return errResp
+	}
+
+	previous := getBit(data, offset)
+	data = setBit(data, offset, bit)
+	s.data[args[0]] = valueEntry{Value: string(data), Expiration: entry.Expiration}
+
+	return formatInteger(int64(previous))
+}
+
+func (s *Server) handleGetBit(args []string) string {
+	if len(args) != 2 {
+		return wrongArgs("GETBIT")
+	}
+
+	offset, ok := parseBitOffset(args[1])
+	if !ok {
+		return bitOffsetError
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, _, errResp := s.stringBytes(args[0])
+	if errResp != "" {
+		return errResp
+	}
+
+	return formatInteger(int64(getBit(data, offset)))
+}
+
+// bitRange converts Redis style start/end arguments, which may be negative
+// and are expressed in bytes or bits, into an inclusive bit range over data.
+// ok is false when the range is empty.
+func bitRange(data []byte, startArg, endArg string, unit string) (startBit, endBit int64, ok bool, errResp string) {
+	start, err := strconv.ParseInt(startArg, 10, 64)
+	if err != nil {
+		return 0, 0, false, integerError
+	}
+	end, err := strconv.ParseInt(endArg, 10, 64)
+	if err != nil {
+		return 0, 0, false, integerError
+	}
+
+	total := int64(len(data))
+	switch strings.ToUpper(unit) {
+	case "", "BYTE":
+	case "BIT":
+		total *= 8
+	default:
+		return 0, 0, false, "(error) ERR syntax error"
+	}
+
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end || total == 0 {
+		return 0, 0, false, ""
+	}
+
+	if strings.ToUpper(unit) == "BIT" {
+		return start, end, true, ""
+	}
+	return start * 8, end*8 + 7, true, ""
+}
+
+func (s *Server) handleBitCount(args []string) string {
+	if len(args) < 1 || len(args) > 4 {
+		return wrongArgs("BITCOUNT")
+	}
+	if len(args) == 2 {
+		return "(error) ERR syntax error"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, _, errResp := s.stringBytes(args[0])
+	if errResp != "" {
+		return errResp
+	}
+
+	if len(args) == 1 {
+		count := 0
+		for _, b := range data {
+			count += bits.OnesCount8(b)
+		}
+		return formatInteger(int64(count))
+	}
+
+	unit := ""
+	if len(args) == 4 {
+		unit = args[3]
+	}
+	start, end, ok, errResp := bitRange(data, args[1], args[2], unit)
+	if errResp != "" {
+		return errResp
+	}
+	if !ok {
+		return formatInteger(0)
+	}
+
+	count := 0
+	for offset := start; offset <= end; offset++ {
+		count += getBit(data, uint64(offset))
+	}
+	return formatInteger(int64(count))
+}
+
+func (s *Server) handleBitPos(args []string) string {
+	if len(args) < 2 || len(args) > 5 {
+		return wrongArgs("BITPOS")
+	}
+	if args[1] != "0" && args[1] != "1" {
+		return "(error) ERR The bit argument must be 1 or 0."
+	}
+	bit := int(args[1][0] - '0')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, _, errResp := s.stringBytes(args[0])
+	if errResp != "" {
+		return errResp
+	}
+	if data == nil {
+		if bit == 0 {
+			return formatInteger(0)
+		}
+		return formatInteger(-1)
+	}
+
+	startArg, endArg, unit := "0", "-1", ""
+	endGiven := false
+	if len(args) >= 3 {
+		startArg = args[2]
+	}
+	if len(args) >= 4 {
+		endArg = args[3]
+		endGiven = true
+	}
+	if len(args) == 5 {
+		unit = args[4]
+	}
+
+	start, end, ok, errResp := bitRange(data, startArg, endArg, unit)
+	if errResp != "" {
+		return errResp
+	}
+	if !ok {
+		return formatInteger(-1)
+	}
+
+	for offset := start; offset <= end; offset++ {
+		if getBit(data, uint64(offset)) == bit {
+			return formatInteger(offset)
+		}
+	}
+
+	// Looking for a clear bit without an explicit end treats the string as
+	// padded with zeros on the right.
+	if bit == 0 && !endGiven {
+		return formatInteger(end + 1)
+	}
+	return formatInteger(-1)
+}
+
+func (s *Server) handleBitOp(args []string) string {
+	if len(args) < 3 {
+		return wrongArgs("BITOP")
+	}
+
+	op := strings.ToUpper(args[0])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 3 {
+			return "(error) ERR BITOP NOT must be called with a single source key."
+		}
+	default:
+		return "(error) ERR syntax error"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := make([][]byte, 0, len(args)-2)
+	maxLen := 0
+	for _, key := range args[2:] {
+		data, _, errResp := s.stringBytes(key)
+		if errResp != "" {
+			return errResp
+		}
+		sources = append(sources, data)
+		if len(data) > maxLen {
+			maxLen = len(data)
+		}
+	}
+
+	destKey := args[1]
+	if maxLen == 0 {
+		delete(s.data, destKey)
+		return formatInteger(0)
+	}
+
+	result := make([]byte, maxLen)
+	for i := range result {
+		var acc byte
+		for j, source := range sources {
+			var b byte
+			if i < len(source) {
+				b = source[i]
+			}
+			if j == 0 {
+				acc = b
+				continue
+			}
+			switch op {
+			case "AND":
+				acc &= b
+			case "OR":
+				acc |= b
+			case "XOR":
+				acc ^= b
+			}
+		}
+		if op == "NOT" {
+			acc = ^acc
+		}
+		result[i] = acc
+	}
+	s.data[destKey] = valueEntry{Value: string(result)}
+
+	return formatInteger(int64(maxLen))
+}
+
+type bitfieldType struct {
+	signed bool
+	bits   uint
+}
+
+func parseBitfieldType(arg string) (bitfieldType, bool) {
+	if len(arg) < 2 {
+		return bitfieldType{}, false
+	}
+	var t bitfieldType
+	switch arg[0] {
+	case 'i', 'I':
+		t.signed = true
+	case 'u', 'U':
+	default:
+		return bitfieldType{}, false
+	}
+	n, err := strconv.Atoi(arg[1:])
+	if err != nil || n < 1 || (t.signed && n > 64) || (!t.signed && n > 63) {
+		return bitfieldType{}, false
+	}
+	t.bits = uint(n)
+	return t, true
+}
+
+// parseBitfieldOffset accepts either an absolute bit offset or a "#N" offset
+// that is multiplied by the field width.
+func parseBitfieldOffset(arg string, t bitfieldType) (uint64, bool) {
+	multiply := strings.HasPrefix(arg, "#")
+	offset, err := strconv.ParseUint(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	// Check the bounds before any arithmetic so that huge offsets cannot
+	// wrap around.
+	bits := uint64(t.bits)
+	if multiply {
+		if offset > maxBitOffset/bits {
+			return 0, false
+		}
+		offset *= bits
+	}
+	if offset > maxBitOffset-(bits-1) {
+		return 0, false
+	}
+	return offset, true
+}
+
+func readField(data []byte, offset uint64, t bitfieldType) int64 {
+	var raw uint64
+	for i := uint64(0); i < uint64(t.bits); i++ {
+		raw = raw<<1 | uint64(getBit(data, offset+i))
+	}
+	if t.signed && t.bits < 64 && raw&(1<<(t.bits-1)) != 0 {
+		raw |= ^uint64(0) << t.bits
+	}
+	return int64(raw)
+}
+
+func writeField(data []byte, offset uint64, t bitfieldType, value int64) []byte {
+	raw := uint64(value)
+	for i := uint64(0); i < uint64(t.bits); i++ {
+		bit := int(raw>>(uint64(t.bits)-1-i)) & 1
+		data = setBit(data, offset+i, bit)
+	}
+	return data
+}
+
+// applyOverflow computes value+incr for a field of type t according to the
+// overflow mode. ok is false when the mode is FAIL and the result does not
+// fit.
+func applyOverflow(value, incr int64, t bitfieldType, mode string) (result int64, ok bool) {
+	sum := new(big.Int).Add(big.NewInt(value), big.NewInt(incr))
+
+	var min, max *big.Int
+	if t.signed {
+		max = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), t.bits-1), big.NewInt(1))
+		min = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), t.bits-1))
+	} else {
+		max = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), t.bits), big.NewInt(1))
+		min = big.NewInt(0)
+	}
+
+	if sum.Cmp(min) >= 0 && sum.Cmp(max) <= 0 {
+		return sum.Int64(), true
+	}
+
+	switch mode {
+	case "SAT":
+		if sum.Cmp(max) > 0 {
+			return max.Int64(), true
+		}
+		return min.Int64(), true
+	case "FAIL":
+		return 0, false
+	}
+
+	modulus := new(big.Int).Lsh(big.NewInt(1), t.bits)
+	wrapped := new(big.Int).Mod(sum, modulus)
+	if t.signed && wrapped.Cmp(max) > 0 {
+		wrapped.Sub(wrapped, modulus)
+	}
+	return wrapped.Int64(), true
+}
+
+func (s *Server) handleBitField(args []string) string {
+	if len(args) < 1 {
+		return wrongArgs("BITFIELD")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args[0]
+	data, entry, errResp := s.stringBytes(key)
+	if errResp != "" {
+		return errResp
+	}
+
+	results := []string{}
+	overflow := "WRAP"
+	written := false
+
+	for i := 1; i < len(args); i++ {
+		subcommand := strings.ToUpper(args[i])
+
+		if subcommand == "OVERFLOW" {
+			if i+1 >= len(args) {
+				return "(error) ERR syntax error"
+			}
+			mode := strings.ToUpper(args[i+1])
+			if mode != "WRAP" && mode != "SAT" && mode != "FAIL" {
+				return "(error) ERR Invalid OVERFLOW type specified"
+			}
+			overflow = mode
+			i++
+			continue
+		}
+
+		needed := 2
+		if subcommand == "SET" || subcommand == "INCRBY" {
+			needed = 3
+		} else if subcommand != "GET" {
+			return "(error) ERR syntax error"
+		}
+		if i+needed >= len(args) {
+			return "(error) ERR syntax error"
+		}
+
+		t, ok := parseBitfieldType(args[i+1])
+		if !ok {
+			return bitTypeError
+		}
+		offset, ok := parseBitfieldOffset(args[i+2], t)
+		if !ok {
+			return bitOffsetError
+		}
+
+		switch subcommand {
+		case "GET":
+			results = append(results, formatInteger(readField(data, offset, t)))
+		case "SET", "INCRBY":
+			operand, err := strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				return integerError
+			}
+			previous := readField(data, offset, t)
+
+			value, incr := operand, int64(0)
+			if subcommand == "INCRBY" {
+				value, incr = previous, operand
+			}
+			result, ok := applyOverflow(value, incr, t, overflow)
+			if !ok {
+				results = append(results, "(nil)")
+				break
+			}
+			data = writeField(data, offset, t, result)
+			written = true
+
+			if subcommand == "SET" {
+				results = append(results, formatInteger(previous))
+			} else {
+				results = append(results, formatInteger(result))
+			}
+		}
+		i += needed
+	}
+
+	if written {
+		s.data[key] = valueEntry{Value: string(data), Expiration: entry.Expiration}
+	}
+
+	return formatArray(results)
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity  = 100
+	bloomDefaultExpansion = 2
+
+	// bloomTighteningRatio scales the error rate of every new sub-filter so
+	// that the compound false positive rate stays below the requested one.
+	bloomTighteningRatio = 0.5
+
+	// bloomMaxLayerBits caps the bit array of a single sub-filter at
+	// 128 MiB.
+	bloomMaxLayerBits = 1 << 30
+)
+
+var (
+	errBloomFull     = errors.New("ERR non scaling filter is full")
+	errBloomTooLarge = errors.New("ERR filter would exceed the maximum size")
+)
+
+// bloomFilter is a scalable Bloom filter. When the newest sub-filter reaches
+// its capacity a larger one is stacked on top, unless the filter was
+// reserved as non-scaling.
+type bloomFilter struct {
+	errorRate  float64
+	expansion  int64
+	nonScaling bool
+	layers     []*bloomLayer
+}
+
+type bloomLayer struct {
+	bits     []uint64
+	size     uint64
+	hashes   int
+	capacity int64
+	count    int64
+}
+
+func newBloomFilter(errorRate float64, capacity, expansion int64, nonScaling bool) (*bloomFilter, error) {
+	layer, err := newBloomLayer(capacity, errorRate)
+	if err != nil {
+		return nil, err
+	}
+	return &bloomFilter{
+		errorRate:  errorRate,
+		expansion:  expansion,
+		nonScaling: nonScaling,
+		layers:     []*bloomLayer{layer},
+	}, nil
+}
+
+// newBloomLayer sizes a sub-filter for capacity items at errorRate. It
+// fails rather than allocate more than bloomMaxLayerBits.
+func newBloomLayer(capacity int64, errorRate float64) (*bloomLayer, error) {
+	bits := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	if bits > bloomMaxLayerBits {
+		return nil, errBloomTooLarge
+	}
+	size := uint64(bits)
+	if size < 64 {
+		size = 64
+	}
+	hashes := int(math.Ceil(math.Ln2 * float64(size) / float64(capacity)))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &bloomLayer{
+		bits:     make([]uint64, (size+63)/64),
+		size:     size,
+		hashes:   hashes,
+		capacity: capacity,
+	}, nil
+}
+
+// positions derives the bit positions of item with double hashing over the
+// two halves of a single 64-bit hash.
+func (l *bloomLayer) positions(hash uint64) []uint64 {
+	h1 := hash & 0xffffffff
+	// An even step would only reach part of the positions when size is
+	// even, so force it odd.
+	h2 := hash>>32 | 1
+	positions := make([]uint64, l.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % l.size
+	}
+	return positions
+}
+
+func (l *bloomLayer) contains(hash uint64) bool {
+	for _, p := range l.positions(hash) {
+		if l.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) insert(hash uint64) {
+	for _, p := range l.positions(hash) {
+		l.bits[p/64] |= 1 << (p % 64)
+	}
+	l.count++
+}
+
+func (b *bloomFilter) exists(item string) bool {
+	hash := hash64(item)
+	for _, layer := range b.layers {
+		if layer.contains(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bloomFilter) add(item string) (bool, error) {
+	if b.exists(item) {
+		return false, nil
+	}
+
+	layer := b.layers[len(b.layers)-1]
+	if layer.count >= layer.capacity {
+		if b.nonScaling {
+			return false, errBloomFull
+		}
+		if layer.capacity > math.MaxInt64/b.expansion {
+			return false, errBloomTooLarge
+		}
+		rate := b.errorRate * math.Pow(bloomTighteningRatio, float64(len(b.layers)))
+		next, err := newBloomLayer(layer.capacity*b.expansion, rate)
+		if err != nil {
+			return false, err
+		}
+		layer = next
+		b.layers = append(b.layers, layer)
+	}
+
+	layer.insert(hash64(item))
+	return true, nil
+}
+
+func (s *Server) handleBFReserve(args []string) string {
+	if len(args) < 3 {
+		return wrongArgs("BF.RESERVE")
+	}
+
+	errorRate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return "(error) ERR bad error rate"
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return "(error) ERR (0 < error rate range < 1)"
+	}
+	capacity, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return "(error) ERR bad capacity"
+	}
+	if capacity <= 0 {
+		return "(error) ERR (capacity should be larger than 0)"
+	}
+
+	expansion := int64(bloomDefaultExpansion)
+	nonScaling := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return "(error) ERR syntax error"
+			}
+			expansion, err = strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || expansion < 1 {
+				return "(error) ERR expansion should be greater or equal to 1"
+			}
+			i++
+		case "NONSCALING":
+			nonScaling = true
+		default:
+			return "(error) ERR syntax error"
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args[0]
+	if _, ok := s.lookup(key); ok {
+		return "(error) ERR item exists"
+	}
+	filter, err := newBloomFilter(errorRate, capacity, expansion, nonScaling)
+	if err != nil {
+		return "(error) " + err.Error()
+	}
+	s.data[key] = valueEntry{Value: filter}
+
+	return "OK"
+}
+
+func (s *Server) handleBFAdd(args []string) string {
+	if len(args) != 2 {
+		return wrongArgs("BF.ADD")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args[0]
+	entry, ok := s.lookup(key)
+	if !ok {
+		filter, err := newBloomFilter(bloomDefaultErrorRate, bloomDefaultCapacity, bloomDefaultExpansion, false)
+		if err != nil {
+			return "(error) " + err.Error()
+		}
+		entry = valueEntry{Value: filter}
+		s.data[key] = entry
+	}
+	filter, isBloom := entry.Value.(*bloomFilter)
+	if !isBloom {
+		return wrongTypeError
+	}
+
+	added, err := filter.add(args[1])
+	if err != nil {
+		return "(error) " + err.Error()
+	}
+	if added {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
+
+func (s *Server) handleBFExists(args []string) string {
+	if len(args) != 2 {
+		return wrongArgs("BF.EXISTS")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.lookup(args[0])
+	if !ok {
+		return formatInteger(0)
+	}
+	filter, isBloom := entry.Value.(*bloomFilter)
+	if !isBloom {
+		return wrongTypeError
+	}
+
+	if filter.exists(args[1]) {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
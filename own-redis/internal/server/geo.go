@@ -0,0 +1,486 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	geoLatMin   = -85.05112878
+	geoLatMax   = 85.05112878
+	geoLonMin   = -180.0
+	geoLonMax   = 180.0
+	geoStep     = 26
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	earthRadiusMeters = 6372797.560856
+
+	geoUnitError = "(error) ERR unsupported unit provided. please use M, KM, FT, MI"
+)
+
+var geoUnits = map[string]float64{
+	"M":  1,
+	"KM": 1000,
+	"FT": 0.3048,
+	"MI": 1609.34,
+}
+
+// geoEncode interleaves the quantized latitude and longitude into a 52-bit
+// geohash. The result is exactly representable as a float64 and is used as
+// the member's sorted set score.
+func geoEncode(lon, lat, latMin, latMax float64) uint64 {
+	latOffset := (lat - latMin) / (latMax - latMin)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin)
+	latBits := uint64(latOffset * (1 << geoStep))
+	lonBits := uint64(lonOffset * (1 << geoStep))
+	if latBits >= 1<<geoStep {
+		latBits = 1<<geoStep - 1
+	}
+	if lonBits >= 1<<geoStep {
+		lonBits = 1<<geoStep - 1
+	}
+	return interleave(latBits) | interleave(lonBits)<<1
+}
+
+// geoDecode returns the center of the cell described by hash.
+func geoDecode(hash uint64) (lon, lat float64) {
+	latBits := deinterleave(hash)
+	lonBits := deinterleave(hash >> 1)
+
+	latScale := geoLatMax - geoLatMin
+	lonScale := geoLonMax - geoLonMin
+	latLow := geoLatMin + float64(latBits)/(1<<geoStep)*latScale
+	latHigh := geoLatMin + float64(latBits+1)/(1<<geoStep)*latScale
+	lonLow := geoLonMin + float64(lonBits)/(1<<geoStep)*lonScale
+	lonHigh := geoLonMin + float64(lonBits+1)/(1<<geoStep)*lonScale
+
+	lon = math.Max(geoLonMin, math.Min(geoLonMax, (lonLow+lonHigh)/2))
+	lat = math.Max(geoLatMin, math.Min(geoLatMax, (latLow+latHigh)/2))
+	return lon, lat
+}
+
+func interleave(v uint64) uint64 {
+	var result uint64
+	for i := 0; i < geoStep; i++ {
+		result |= (v >> i & 1) << (2 * i)
+	}
+	return result
+}
+
+func deinterleave(v uint64) uint64 {
+	var result uint64
+	for i := 0; i < geoStep; i++ {
+		result |= (v >> (2 * i) & 1) << i
+	}
+	return result
+}
+
+// geoHashString renders the standard 11 character geohash. Unlike the score,
+// it is computed over the full [-90, 90] latitude range so that it matches
+// other geohash implementations.
+func geoHashString(lon, lat float64) string {
+	hash := geoEncode(lon, lat, -90, 90)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		if i < 10 {
+			idx = int(hash>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r := lat1 * math.Pi / 180
+	lat2r := lat2 * math.Pi / 180
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * math.Pi / 180 / 2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+func parseLonLat(lonArg, latArg string) (lon, lat float64, errResp string) {
+	lon, err := strconv.ParseFloat(lonArg, 64)
+	if err != nil {
+		return 0, 0, "(error) ERR value is not a valid float"
+	}
+	lat, err = strconv.ParseFloat(latArg, 64)
+	if err != nil {
+		return 0, 0, "(error) ERR value is not a valid float"
+	}
+	if lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, fmt.Sprintf("(error) ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, ""
+}
+
+func formatCoordinate(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatDistance(meters, unit float64) string {
+	return fmt.Sprintf("%.4f", meters/unit)
+}
+
+func (s *Server) handleGeoAdd(args []string) string {
+	if len(args) < 4 {
+		return wrongArgs("GEOADD")
+	}
+
+	key := args[0]
+	nx, xx, ch := false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if nx && xx {
+		return "(error) ERR XX and NX options at the same time are not compatible"
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return "(error) ERR syntax error"
+	}
+
+	scores := make([]float64, 0, len(rest)/3)
+	for j := 0; j < len(rest); j += 3 {
+		lon, lat, errResp := parseLonLat(rest[j], rest[j+1])
+		if errResp != "" {
+			return errResp
+		}
+		scores = append(scores, float64(geoEncode(lon, lat, geoLatMin, geoLatMax)))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(key)
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		if xx {
+			return formatInteger(0)
+		}
+		z = newSortedSet()
+		s.data[key] = valueEntry{Value: z}
+	}
+
+	count := 0
+	for j, score := range scores {
+		member := rest[j*3+2]
+		_, exists := z.score(member)
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		added, changed := z.add(member, score)
+		if added || (ch && changed) {
+			count++
+		}
+	}
+
+	return formatInteger(int64(count))
+}
+
+func (s *Server) handleGeoPos(args []string) string {
+	if len(args) < 1 {
+		return wrongArgs("GEOPOS")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(args[0])
+	if errResp != "" {
+		return errResp
+	}
+
+	items := make([]string, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if z == nil {
+			items = append(items, "(nil)")
+			continue
+		}
+		score, ok := z.score(member)
+		if !ok {
+			items = append(items, "(nil)")
+			continue
+		}
+		lon, lat := geoDecode(uint64(score))
+		items = append(items, formatArray([]string{formatCoordinate(lon), formatCoordinate(lat)}))
+	}
+
+	return formatArray(items)
+}
+
+func (s *Server) handleGeoDist(args []string) string {
+	if len(args) != 3 && len(args) != 4 {
+		return wrongArgs("GEODIST")
+	}
+
+	unit := 1.0
+	if len(args) == 4 {
+		u, ok := geoUnits[strings.ToUpper(args[3])]
+		if !ok {
+			return geoUnitError
+		}
+		unit = u
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(args[0])
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		return "(nil)"
+	}
+	score1, ok1 := z.score(args[1])
+	score2, ok2 := z.score(args[2])
+	if !ok1 || !ok2 {
+		return "(nil)"
+	}
+
+	lon1, lat1 := geoDecode(uint64(score1))
+	lon2, lat2 := geoDecode(uint64(score2))
+	return formatDistance(geoDistance(lon1, lat1, lon2, lat2), unit)
+}
+
+func (s *Server) handleGeoHash(args []string) string {
+	if len(args) < 1 {
+		return wrongArgs("GEOHASH")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(args[0])
+	if errResp != "" {
+		return errResp
+	}
+
+	items := make([]string, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if z == nil {
+			items = append(items, "(nil)")
+			continue
+		}
+		score, ok := z.score(member)
+		if !ok {
+			items = append(items, "(nil)")
+			continue
+		}
+		lon, lat := geoDecode(uint64(score))
+		items = append(items, geoHashString(lon, lat))
+	}
+
+	return formatArray(items)
+}
+
+type geoSearchResult struct {
+	member   string
+	score    float64
+	distance float64
+	lon, lat float64
+}
+
+func (s *Server) handleGeoSearch(args []string) string {
+	if len(args) < 5 {
+		return wrongArgs("GEOSEARCH")
+	}
+
+	key := args[0]
+	var fromMember string
+	var centerLon, centerLat float64
+	hasFrom, hasLonLat := false, false
+	var radius, width, height, unit float64
+	byRadius, byBox := false, false
+	ascending, descending := false, false
+	count, countAny := 0, false
+	withCoord, withDist, withHash := false, false, false
+
+	for i := 1; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if remaining < 1 {
+				return "(error) ERR syntax error"
+			}
+			fromMember = args[i+1]
+			hasFrom = true
+			i++
+		case "FROMLONLAT":
+			if remaining < 2 {
+				return "(error) ERR syntax error"
+			}
+			lon, lat, errResp := parseLonLat(args[i+1], args[i+2])
+			if errResp != "" {
+				return errResp
+			}
+			centerLon, centerLat = lon, lat
+			hasLonLat = true
+			i += 2
+		case "BYRADIUS":
+			if remaining < 2 {
+				return "(error) ERR syntax error"
+			}
+			r, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || r < 0 {
+				return "(error) ERR radius cannot be negative"
+			}
+			u, ok := geoUnits[strings.ToUpper(args[i+2])]
+			if !ok {
+				return geoUnitError
+			}
+			radius, unit = r*u, u
+			byRadius = true
+			i += 2
+		case "BYBOX":
+			if remaining < 3 {
+				return "(error) ERR syntax error"
+			}
+			w, err1 := strconv.ParseFloat(args[i+1], 64)
+			h, err2 := strconv.ParseFloat(args[i+2], 64)
+			if err1 != nil || err2 != nil || w < 0 || h < 0 {
+				return "(error) ERR height or width cannot be negative"
+			}
+			u, ok := geoUnits[strings.ToUpper(args[i+3])]
+			if !ok {
+				return geoUnitError
+			}
+			width, height, unit = w*u, h*u, u
+			byBox = true
+			i += 3
+		case "ASC":
+			ascending = true
+		case "DESC":
+			descending = true
+		case "COUNT":
+			if remaining < 1 {
+				return "(error) ERR syntax error"
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				return "(error) ERR COUNT must be > 0"
+			}
+			count = n
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1], "ANY") {
+				countAny = true
+				i++
+			}
+		case "WITHCOORD":
+			withCoord = true
+		case "WITHDIST":
+			withDist = true
+		case "WITHHASH":
+			withHash = true
+		default:
+			return "(error) ERR syntax error"
+		}
+	}
+
+	if hasFrom == hasLonLat {
+		return "(error) ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"
+	}
+	if byRadius == byBox {
+		return "(error) ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"
+	}
+	if ascending && descending {
+		return "(error) ERR syntax error"
+	}
+	if countAny && count == 0 {
+		return "(error) ERR the ANY argument requires COUNT argument"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(key)
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		return formatArray(nil)
+	}
+
+	if hasFrom {
+		score, ok := z.score(fromMember)
+		if !ok {
+			return "(error) ERR could not decode requested zset member"
+		}
+		centerLon, centerLat = geoDecode(uint64(score))
+	}
+
+	results := []geoSearchResult{}
+	for _, member := range z.members {
+		score := z.scores[member]
+		lon, lat := geoDecode(uint64(score))
+		distance := geoDistance(centerLon, centerLat, lon, lat)
+
+		if byRadius && distance > radius {
+			continue
+		}
+		if byBox {
+			latDistance := geoDistance(centerLon, centerLat, centerLon, lat)
+			lonDistance := geoDistance(centerLon, lat, lon, lat)
+			if latDistance > height/2 || lonDistance > width/2 {
+				continue
+			}
+		}
+
+		results = append(results, geoSearchResult{member: member, score: score, distance: distance, lon: lon, lat: lat})
+		if countAny && len(results) == count {
+			break
+		}
+	}
+
+	// COUNT without ANY returns the closest matches, so it implies sorting.
+	if count > 0 && !countAny && !descending {
+		ascending = true
+	}
+	if ascending {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].distance < results[j].distance })
+	} else if descending {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].distance > results[j].distance })
+	}
+	if count > 0 && len(results) > count {
+		results = results[:count]
+	}
+
+	items := make([]string, 0, len(results))
+	for _, result := range results {
+		if !withCoord && !withDist && !withHash {
+			items = append(items, result.member)
+			continue
+		}
+		fields := []string{result.member}
+		if withDist {
+			fields = append(fields, formatDistance(result.distance, unit))
+		}
+		if withHash {
+			fields = append(fields, formatInteger(int64(result.score)))
+		}
+		if withCoord {
+			fields = append(fields, formatArray([]string{formatCoordinate(result.lon), formatCoordinate(result.lat)}))
+		}
+		items = append(items, formatArray(fields))
+	}
+
+	return formatArray(items)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -67,5 +68,55 @@ func (s *Server) handleGet(args []string) string {
 		return "(nil)"
 	}
 
-	return entry.Value
+	value, ok := entry.Value.(string)
+	if !ok {
+		return wrongTypeError
+	}
+
+	return value
+}
+
+const wrongTypeError = "(error) WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// lookup returns the live entry stored under key, removing it if it has
+// expired. The caller must hold s.mu for writing.
+func (s *Server) lookup(key string) (valueEntry, bool) {
+	entry, ok := s.data[key]
+	if !ok {
+		return valueEntry{}, false
+	}
+	if !entry.Expiration.IsZero() && time.Now().After(entry.Expiration) {
+		delete(s.data, key)
+		return valueEntry{}, false
+	}
+	return entry, true
+}
+
+func formatInteger(n int64) string {
+	return fmt.Sprintf("(integer) %d", n)
+}
+
+// formatArray renders items the way redis-cli prints an array reply. Items
+// spanning several lines, such as nested arrays, are indented under their
+// index.
+func formatArray(items []string) string {
+	if len(items) == 0 {
+		return "(empty array)"
+	}
+	lines := []string{}
+	for i, item := range items {
+		prefix := fmt.Sprintf("%d) ", i+1)
+		for j, line := range strings.Split(item, "\n") {
+			if j == 0 {
+				lines = append(lines, prefix+line)
+			} else {
+				lines = append(lines, strings.Repeat(" ", len(prefix))+line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func wrongArgs(command string) string {
+	return fmt.Sprintf("(error) ERR wrong number of arguments for %s command", command)
 }
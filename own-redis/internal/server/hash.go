@@ -0,0 +1,19 @@
+package server
+
+import "hash/fnv"
+
+// hash64 returns a well-mixed 64-bit hash of s. FNV-1a alone has weak
+// avalanche in the low bits, so the result is passed through the
+// MurmurHash3 finalizer before use.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllDenseSize = hllRegisters * 6 / 8
+
+	// hllSparseMaxEntries is the number of non-zero registers after which a
+	// sparse HyperLogLog is promoted to the dense encoding. At four bytes per
+	// entry this keeps the sparse form well below the dense size.
+	hllSparseMaxEntries = 750
+)
+
+// hyperLogLog is a cardinality estimator with 2^14 six-bit registers.
+// New sketches start sparse, keeping only non-zero registers as sorted
+// (index<<8 | value) pairs, and switch to a packed dense array once they
+// grow past hllSparseMaxEntries.
+type hyperLogLog struct {
+	sparse []uint32
+	dense  []byte
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{sparse: []uint32{}}
+}
+
+func (h *hyperLogLog) isDense() bool {
+	return h.dense != nil
+}
+
+func (h *hyperLogLog) add(element string) bool {
+	hash := hash64(element)
+	index := uint16(hash & (hllRegisters - 1))
+	w := hash>>hllP | 1<<hllQ
+	count := uint8(bits.TrailingZeros64(w) + 1)
+	return h.update(index, count)
+}
+
+func (h *hyperLogLog) update(index uint16, value uint8) bool {
+	if h.isDense() {
+		if denseGet(h.dense, index) >= value {
+			return false
+		}
+		denseSet(h.dense, index, value)
+		return true
+	}
+
+	i := sort.Search(len(h.sparse), func(i int) bool {
+		return uint16(h.sparse[i]>>8) >= index
+	})
+	if i < len(h.sparse) && uint16(h.sparse[i]>>8) == index {
+		if uint8(h.sparse[i]) >= value {
+			return false
+		}
+		h.sparse[i] = uint32(index)<<8 | uint32(value)
+		return true
+	}
+
+	h.sparse = append(h.sparse, 0)
+	copy(h.sparse[i+1:], h.sparse[i:])
+	h.sparse[i] = uint32(index)<<8 | uint32(value)
+
+	if len(h.sparse) > hllSparseMaxEntries {
+		h.promote()
+	}
+	return true
+}
+
+func (h *hyperLogLog) promote() {
+	dense := make([]byte, hllDenseSize)
+	for _, entry := range h.sparse {
+		denseSet(dense, uint16(entry>>8), uint8(entry))
+	}
+	h.dense = dense
+	h.sparse = nil
+}
+
+// forEach calls fn for every non-zero register.
+func (h *hyperLogLog) forEach(fn func(index uint16, value uint8)) {
+	if !h.isDense() {
+		for _, entry := range h.sparse {
+			fn(uint16(entry>>8), uint8(entry))
+		}
+		return
+	}
+	for i := 0; i < hllRegisters; i++ {
+		if v := denseGet(h.dense, uint16(i)); v != 0 {
+			fn(uint16(i), v)
+		}
+	}
+}
+
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	other.forEach(func(index uint16, value uint8) {
+		h.update(index, value)
+	})
+}
+
+// count estimates the cardinality using Ertl's improved estimator, which
+// stays accurate for small sets without a separate linear-counting pass.
+func (h *hyperLogLog) count() int64 {
+	var histogram [hllQ + 2]int
+	nonZero := 0
+	h.forEach(func(_ uint16, value uint8) {
+		histogram[value]++
+		nonZero++
+	})
+	histogram[0] = hllRegisters - nonZero
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for k := hllQ; k >= 1; k-- {
+		z += float64(histogram[k])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	alpha := 0.5 / math.Ln2
+	return int64(math.Round(alpha * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+func denseGet(dense []byte, index uint16) uint8 {
+	bit := int(index) * 6
+	b := bit / 8
+	shift := bit % 8
+	v := uint16(dense[b])
+	if b+1 < len(dense) {
+		v |= uint16(dense[b+1]) << 8
+	}
+	return uint8(v>>shift) & 0x3f
+}
+
+func denseSet(dense []byte, index uint16, value uint8) {
+	bit := int(index) * 6
+	b := bit / 8
+	shift := bit % 8
+	mask := uint16(0x3f) << shift
+	v := uint16(dense[b])
+	if b+1 < len(dense) {
+		v |= uint16(dense[b+1]) << 8
+	}
+	v = v&^mask | uint16(value&0x3f)<<shift
+	dense[b] = byte(v)
+	if b+1 < len(dense) {
+		dense[b+1] = byte(v >> 8)
+	}
+}
+
+func (s *Server) handlePFAdd(args []string) string {
+	if len(args) < 1 {
+		return wrongArgs("PFADD")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args[0]
+	entry, ok := s.lookup(key)
+	created := false
+	if !ok {
+		entry = valueEntry{Value: newHyperLogLog()}
+		created = true
+	}
+	hll, isHLL := entry.Value.(*hyperLogLog)
+	if !isHLL {
+		return wrongTypeError
+	}
+
+	changed := created
+	for _, element := range args[1:] {
+		if hll.add(element) {
+			changed = true
+		}
+	}
+	s.data[key] = entry
+
+	if changed {
+		return formatInteger(1)
+	}
+	return formatInteger(0)
+}
+
+func (s *Server) handlePFCount(args []string) string {
+	if len(args) < 1 {
+		return wrongArgs("PFCOUNT")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	union := newHyperLogLog()
+	for _, key := range args {
+		entry, ok := s.lookup(key)
+		if !ok {
+			continue
+		}
+		hll, isHLL := entry.Value.(*hyperLogLog)
+		if !isHLL {
+			return wrongTypeError
+		}
+		if len(args) == 1 {
+			return formatInteger(hll.count())
+		}
+		union.merge(hll)
+	}
+
+	return formatInteger(union.count())
+}
+
+func (s *Server) handlePFMerge(args []string) string {
+	if len(args) < 1 {
+		return wrongArgs("PFMERGE")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := make([]*hyperLogLog, 0, len(args))
+	for _, key := range args[1:] {
+		entry, ok := s.lookup(key)
+		if !ok {
+			continue
+		}
+		hll, isHLL := entry.Value.(*hyperLogLog)
+		if !isHLL {
+			return wrongTypeError
+		}
+		sources = append(sources, hll)
+	}
+
+	destKey := args[0]
+	dest, ok := s.lookup(destKey)
+	if !ok {
+		dest = valueEntry{Value: newHyperLogLog()}
+	}
+	destHLL, isHLL := dest.Value.(*hyperLogLog)
+	if !isHLL {
+		return wrongTypeError
+	}
+
+	for _, source := range sources {
+		destHLL.merge(source)
+	}
+	s.data[destKey] = dest
+
+	return "OK"
+}
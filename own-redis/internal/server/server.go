@@ -12,17 +12,29 @@ type Server struct {
 	port int
 	data map[string]valueEntry
 	mu   sync.RWMutex
+
+	listenersMu  sync.Mutex
+	udpConn      *net.UDPConn
+	tlsListener  net.Listener
+	tlsConns     map[net.Conn]struct{}
+	active       sync.WaitGroup
+	stopping     chan struct{}
+	stopped      chan struct{}
+	shutdownOnce sync.Once
 }
 
 type valueEntry struct {
-	Value      string
+	Value      any
 	Expiration time.Time
 }
 
 func NewServer(port int) *Server {
 	return &Server{
-		port: port,
-		data: make(map[string]valueEntry),
+		port:     port,
+		data:     make(map[string]valueEntry),
+		tlsConns: make(map[net.Conn]struct{}),
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
 	}
 }
 
@@ -38,6 +50,10 @@ func (s *Server) Start() error {
 	}
 	defer conn.Close()
 
+	if !s.trackUDP(conn) {
+		return nil
+	}
+
 	buffer := make([]byte, 4096)
 	fmt.Printf("Server listening on %s\n", addr)
 
@@ -46,17 +62,37 @@ func (s *Server) Start() error {
 	for {
 		n, clientAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
+			if s.isStopping() {
+				<-s.stopped
+				return nil
+			}
 			fmt.Printf("Error reading from UDP: %v\n", err)
 			continue
 		}
 
-		go s.handleRequest(conn, clientAddr, buffer[:n])
+		if !s.trackRequest() {
+			// Shutdown has begun; drop the datagram. The next read fails
+			// on the deadline Shutdown set.
+			continue
+		}
+		go s.handleRequest(conn, clientAddr, string(buffer[:n]))
 	}
 }
 
-func (s *Server) handleRequest(conn *net.UDPConn, clientAddr *net.UDPAddr, message []byte) {
-	request := strings.TrimSpace(string(message))
-	parts := strings.Fields(request)
+func (s *Server) handleRequest(conn *net.UDPConn, clientAddr *net.UDPAddr, message string) {
+	defer s.active.Done()
+
+	response := s.execute(message)
+
+	if _, err := conn.WriteToUDP([]byte(response+"\n"), clientAddr); err != nil {
+		fmt.Printf("Error sending response to %v: %v\n", clientAddr, err)
+	}
+}
+
+// execute runs a single command line and returns its reply. It is shared by
+// the UDP and TLS listeners.
+func (s *Server) execute(request string) string {
+	parts := strings.Fields(strings.TrimSpace(request))
 	response := ""
 
 	if len(parts) == 0 {
@@ -67,24 +103,74 @@ func (s *Server) handleRequest(conn *net.UDPConn, clientAddr *net.UDPAddr, messa
 		switch command {
 		case "PING":
 			response = "PONG"
+		case "SHUTDOWN":
+			response = s.handleShutdown(parts[1:])
 		case "SET":
 			response = s.handleSet(parts[1:])
 		case "GET":
 			response = s.handleGet(parts[1:])
+		case "PFADD":
+			response = s.handlePFAdd(parts[1:])
+		case "PFCOUNT":
+			response = s.handlePFCount(parts[1:])
+		case "PFMERGE":
+			response = s.handlePFMerge(parts[1:])
+		case "BF.RESERVE":
+			response = s.handleBFReserve(parts[1:])
+		case "BF.ADD":
+			response = s.handleBFAdd(parts[1:])
+		case "BF.EXISTS":
+			response = s.handleBFExists(parts[1:])
+		case "SETBIT":
+			response = s.handleSetBit(parts[1:])
+		case "GETBIT":
+			response = s.handleGetBit(parts[1:])
+		case "BITCOUNT":
+			response = s.handleBitCount(parts[1:])
+		case "BITPOS":
+			response = s.handleBitPos(parts[1:])
+		case "BITOP":
+			response = s.handleBitOp(parts[1:])
+		case "BITFIELD":
+			response = s.handleBitField(parts[1:])
+		case "ZADD":
+			response = s.handleZAdd(parts[1:])
+		case "ZSCORE":
+			response = s.handleZScore(parts[1:])
+		case "ZREM":
+			response = s.handleZRem(parts[1:])
+		case "ZCARD":
+			response = s.handleZCard(parts[1:])
+		case "ZRANGE":
+			response = s.handleZRange(parts[1:])
+		case "GEOADD":
+			response = s.handleGeoAdd(parts[1:])
+		case "GEOPOS":
+			response = s.handleGeoPos(parts[1:])
+		case "GEODIST":
+			response = s.handleGeoDist(parts[1:])
+		case "GEOHASH":
+			response = s.handleGeoHash(parts[1:])
+		case "GEOSEARCH":
+			response = s.handleGeoSearch(parts[1:])
 		default:
 			response = fmt.Sprintf("(error) ERR unknown command %s", parts[0])
 		}
 	}
 
-	if _, err := conn.WriteToUDP([]byte(response+"\n"), clientAddr); err != nil {
-		fmt.Printf("Error sending response to %v: %v\n", clientAddr, err)
-	}
+	return response
 }
 
 func (s *Server) expiredKeysCleanup() {
 	ticker := time.NewTicker(1 * time.Second)
 	go func() {
-		for range ticker.C {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopping:
+				return
+			case <-ticker.C:
+			}
 			now := time.Now()
 			s.mu.Lock()
 			for key, entry := range s.data {
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// shutdownTimeout bounds how long Shutdown waits for in-flight requests.
+const shutdownTimeout = 10 * time.Second
+
+func (s *Server) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackUDP registers the UDP socket so Shutdown can interrupt its reads. It
+// returns false if the server is already shutting down.
+func (s *Server) trackUDP(conn *net.UDPConn) bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if s.isStopping() {
+		return false
+	}
+	s.udpConn = conn
+	return true
+}
+
+func (s *Server) trackTLSListener(listener net.Listener) bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if s.isStopping() {
+		return false
+	}
+	s.tlsListener = listener
+	return true
+}
+
+func (s *Server) trackTLSConn(conn net.Conn) bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if s.isStopping() {
+		return false
+	}
+	s.tlsConns[conn] = struct{}{}
+	s.active.Add(1)
+	return true
+}
+
+// trackRequest counts a UDP request as active. It returns false if the
+// server is already shutting down, so that Shutdown never waits on a request
+// it did not see start.
+func (s *Server) trackRequest() bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if s.isStopping() {
+		return false
+	}
+	s.active.Add(1)
+	return true
+}
+
+func (s *Server) untrackTLSConn(conn net.Conn) {
+	s.listenersMu.Lock()
+	delete(s.tlsConns, conn)
+	s.listenersMu.Unlock()
+	s.active.Done()
+}
+
+// Shutdown stops accepting new requests, lets in-flight requests finish and
+// then releases Start. Reads are interrupted with deadlines rather than by
+// closing sockets, so replies to requests already being processed can still
+// be written. It is safe to call more than once.
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		fmt.Println("Shutting down: no longer accepting requests")
+
+		s.listenersMu.Lock()
+		close(s.stopping)
+		if s.udpConn != nil {
+			s.udpConn.SetReadDeadline(time.Now())
+		}
+		if s.tlsListener != nil {
+			s.tlsListener.Close()
+		}
+		for conn := range s.tlsConns {
+			conn.SetReadDeadline(time.Now())
+		}
+		s.listenersMu.Unlock()
+
+		drained := make(chan struct{})
+		go func() {
+			s.active.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+			fmt.Println("All active requests finished")
+		case <-time.After(shutdownTimeout):
+			fmt.Printf("Timed out after %s waiting for active requests\n", shutdownTimeout)
+		}
+
+		// own-redis keeps its data in memory only; there is no AOF or
+		// snapshot to flush before exiting.
+
+		close(s.stopped)
+		fmt.Println("Server stopped")
+	})
+}
+
+func (s *Server) handleShutdown(args []string) string {
+	if len(args) > 1 {
+		return "(error) ERR syntax error"
+	}
+	if len(args) == 1 {
+		mode := strings.ToUpper(args[0])
+		if mode != "SAVE" && mode != "NOSAVE" {
+			return "(error) ERR syntax error"
+		}
+	}
+
+	// Shutdown waits for this request to finish, so it has to run
+	// asynchronously for the reply to be sent first.
+	go s.Shutdown()
+
+	return "OK"
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortedSet keeps members ordered by score, then lexicographically. Scores
+// are also indexed by member for constant time lookups.
+type sortedSet struct {
+	scores  map[string]float64
+	members []string
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64)}
+}
+
+func (z *sortedSet) less(a string, scoreA float64, b string) bool {
+	scoreB := z.scores[b]
+	if scoreA != scoreB {
+		return scoreA < scoreB
+	}
+	return a < b
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// add inserts member or moves it to its new score. It reports whether the
+// member was new and whether its score changed.
+func (z *sortedSet) add(member string, score float64) (added, changed bool) {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false, false
+		}
+		z.remove(member)
+	}
+
+	i := sort.Search(len(z.members), func(i int) bool {
+		return z.less(member, score, z.members[i])
+	})
+	z.members = append(z.members, "")
+	copy(z.members[i+1:], z.members[i:])
+	z.members[i] = member
+	z.scores[member] = score
+
+	return !exists, true
+}
+
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	i := sort.Search(len(z.members), func(i int) bool {
+		s := z.scores[z.members[i]]
+		return s > score || s == score && z.members[i] >= member
+	})
+	z.members = append(z.members[:i], z.members[i+1:]...)
+	delete(z.scores, member)
+	return true
+}
+
+func (z *sortedSet) len() int {
+	return len(z.members)
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// sortedSetAt returns the sorted set stored under key. A missing key yields
+// nil without an error response. The caller must hold s.mu for writing.
+func (s *Server) sortedSetAt(key string) (*sortedSet, string) {
+	entry, ok := s.lookup(key)
+	if !ok {
+		return nil, ""
+	}
+	z, isSortedSet := entry.Value.(*sortedSet)
+	if !isSortedSet {
+		return nil, wrongTypeError
+	}
+	return z, ""
+}
+
+func (s *Server) handleZAdd(args []string) string {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return wrongArgs("ZADD")
+	}
+
+	scores := make([]float64, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || math.IsNaN(score) {
+			return "(error) ERR value is not a valid float"
+		}
+		scores = append(scores, score)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args[0]
+	z, errResp := s.sortedSetAt(key)
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		z = newSortedSet()
+		s.data[key] = valueEntry{Value: z}
+	}
+
+	added := 0
+	for i, score := range scores {
+		if isNew, _ := z.add(args[2+i*2], score); isNew {
+			added++
+		}
+	}
+
+	return formatInteger(int64(added))
+}
+
+func (s *Server) handleZScore(args []string) string {
+	if len(args) != 2 {
+		return wrongArgs("ZSCORE")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(args[0])
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		return "(nil)"
+	}
+	score, ok := z.score(args[1])
+	if !ok {
+		return "(nil)"
+	}
+
+	return formatScore(score)
+}
+
+func (s *Server) handleZRem(args []string) string {
+	if len(args) < 2 {
+		return wrongArgs("ZREM")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args[0]
+	z, errResp := s.sortedSetAt(key)
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		return formatInteger(0)
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if z.remove(member) {
+			removed++
+		}
+	}
+	if z.len() == 0 {
+		delete(s.data, key)
+	}
+
+	return formatInteger(int64(removed))
+}
+
+func (s *Server) handleZCard(args []string) string {
+	if len(args) != 1 {
+		return wrongArgs("ZCARD")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(args[0])
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		return formatInteger(0)
+	}
+
+	return formatInteger(int64(z.len()))
+}
+
+func (s *Server) handleZRange(args []string) string {
+	if len(args) != 3 && len(args) != 4 {
+		return wrongArgs("ZRANGE")
+	}
+	withScores := len(args) == 4
+	if withScores && !strings.EqualFold(args[3], "WITHSCORES") {
+		return "(error) ERR syntax error"
+	}
+
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return integerError
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return integerError
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, errResp := s.sortedSetAt(args[0])
+	if errResp != "" {
+		return errResp
+	}
+	if z == nil {
+		return formatArray(nil)
+	}
+
+	n := z.len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+
+	items := []string{}
+	for i := start; i <= stop; i++ {
+		member := z.members[i]
+		items = append(items, member)
+		if withScores {
+			items = append(items, formatScore(z.scores[member]))
+		}
+	}
+
+	return formatArray(items)
+}
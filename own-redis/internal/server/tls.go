@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+type TLSOptions struct {
+	Port        int
+	CertFile    string
+	KeyFile     string
+	CACertFile  string
+	AuthClients string
+}
+
+// tlsReloader holds the active TLS configuration so that certificates can be
+// swapped on SIGHUP without restarting the listener.
+type tlsReloader struct {
+	opts   TLSOptions
+	config atomic.Pointer[tls.Config]
+}
+
+func (r *tlsReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if (r.opts.AuthClients == "yes" || r.opts.AuthClients == "optional") && r.opts.CACertFile == "" {
+		return fmt.Errorf("client authentication %q needs a CA certificate file", r.opts.AuthClients)
+	}
+
+	if r.opts.CACertFile != "" {
+		pem, err := os.ReadFile(r.opts.CACertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no valid certificates found in CA certificate file")
+		}
+		config.ClientCAs = pool
+
+		switch r.opts.AuthClients {
+		case "yes":
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	r.config.Store(config)
+	return nil
+}
+
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.config.Load(), nil
+}
+
+func (r *tlsReloader) watchSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := r.load(); err != nil {
+				fmt.Printf("TLS reload failed, keeping previous certificates: %v\n", err)
+				continue
+			}
+			fmt.Println("TLS certificates reloaded")
+		}
+	}()
+}
+
+// StartTLS serves the same line based protocol as the UDP listener over
+// TLS encrypted TCP connections. Each line is one command.
+func (s *Server) StartTLS(opts TLSOptions) error {
+	reloader := &tlsReloader{opts: opts}
+	if err := reloader.load(); err != nil {
+		return err
+	}
+	reloader.watchSIGHUP()
+
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", opts.Port), &tls.Config{
+		GetConfigForClient: reloader.getConfigForClient,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to listen TLS: %w", err)
+	}
+	defer listener.Close()
+
+	if !s.trackTLSListener(listener) {
+		return nil
+	}
+
+	fmt.Printf("TLS server listening on %s\n", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isStopping() {
+				return nil
+			}
+			fmt.Printf("Error accepting TLS connection: %v\n", err)
+			continue
+		}
+
+		if !s.trackTLSConn(conn) {
+			conn.Close()
+			continue
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.untrackTLSConn(conn)
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		response := s.execute(scanner.Text())
+		if _, err := conn.Write([]byte(response + "\n")); err != nil {
+			fmt.Printf("Error sending response to %v: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && !s.isStopping() {
+		fmt.Printf("Error reading from %v: %v\n", conn.RemoteAddr(), err)
+	}
+}
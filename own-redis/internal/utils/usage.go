@@ -6,10 +6,18 @@ func Usage() {
 	fmt.Println(`Own Redis
 
 Usage:
-  own-redis [--port <N>]
+  own-redis [--port <N>] [--tls-port <N> --tls-cert-file <F> --tls-key-file <F> [--tls-ca-cert-file <F>] [--tls-auth-clients <yes|no|optional>]]
   own-redis --help
 
 Options:
-  --help       Show this screen.
-  --port N     Port number.`)
+  --help                  Show this screen.
+  --port N                Port number.
+  --tls-port N            TLS over TCP port number. Disabled by default.
+  --tls-cert-file F       Server certificate (PEM). Reloaded on SIGHUP.
+  --tls-key-file F        Server private key (PEM). Reloaded on SIGHUP.
+  --tls-ca-cert-file F    CA certificates used to verify client certificates.
+  --tls-auth-clients M    Client certificate verification: yes (required),
+                          optional or no. yes and optional need
+                          --tls-ca-cert-file. Default yes with a CA file,
+                          no without one.`)
 }
@@ -3,16 +3,43 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"own-redis/internal/flags"
 	"own-redis/internal/server"
 )
 
 func main() {
-	port := flags.FlagInit()
+	config := flags.FlagInit()
 
-	server := server.NewServer(port)
-	err := server.Start()
+	srv := server.NewServer(config.Port)
+
+	if config.TLSPort != 0 {
+		opts := server.TLSOptions{
+			Port:        config.TLSPort,
+			CertFile:    config.TLSCertFile,
+			KeyFile:     config.TLSKeyFile,
+			CACertFile:  config.TLSCACertFile,
+			AuthClients: config.TLSAuthClients,
+		}
+		go func() {
+			if err := srv.StartTLS(opts); err != nil {
+				fmt.Fprintf(os.Stderr, "TLS server error: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Printf("Received %s\n", sig)
+		srv.Shutdown()
+	}()
+
+	err := srv.Start()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxChunkHeader bounds the length of a chunk header or trailer line.
+const maxChunkHeader = 4096
+
+var hexSHA256 = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// WrapBody replaces the request body with one that enforces the payload
+// hash covered by the signature. A hex digest is checked once the body has
+// been read in full, and aws-chunked bodies are decoded with every chunk
+// signature verified. Readers return ErrContentSHA256Mismatch,
+// ErrSignatureMismatch or ErrMalformedChunk instead of io.EOF when the body
+// does not match, so a partially stored upload is discarded.
+func WrapBody(r *http.Request, sig *Signature) error {
+	switch sig.PayloadHash {
+	case UnsignedPayload:
+		return nil
+	case StreamingPayload, StreamingPayloadTrailer, StreamingUnsignedPayloadTrailer:
+		decoded, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil || decoded < 0 {
+			return ErrMalformedChunk
+		}
+		r.Body = &chunkedReader{
+			body:      r.Body,
+			r:         bufio.NewReaderSize(r.Body, maxChunkHeader),
+			signed:    sig.PayloadHash != StreamingUnsignedPayloadTrailer,
+			sig:       sig,
+			prevSig:   sig.Signature,
+			chunkHash: sha256.New(),
+		}
+		r.ContentLength = decoded
+		r.Header.Set("Content-Length", strconv.FormatInt(decoded, 10))
+		r.Header.Del("X-Amz-Decoded-Content-Length")
+		stripAWSChunked(r.Header)
+		return nil
+	}
+
+	if !hexSHA256.MatchString(sig.PayloadHash) {
+		return ErrMalformedAuth
+	}
+	r.Body = &hashingReader{body: r.Body, hash: sha256.New(), expected: sig.PayloadHash}
+	return nil
+}
+
+// stripAWSChunked removes the aws-chunked transfer coding from
+// Content-Encoding so that it is not stored as object metadata.
+func stripAWSChunked(header http.Header) {
+	codings := []string{}
+	for _, coding := range strings.Split(header.Get("Content-Encoding"), ",") {
+		coding = strings.TrimSpace(coding)
+		if coding != "" && !strings.EqualFold(coding, "aws-chunked") {
+			codings = append(codings, coding)
+		}
+	}
+	if len(codings) == 0 {
+		header.Del("Content-Encoding")
+		return
+	}
+	header.Set("Content-Encoding", strings.Join(codings, ","))
+}
+
+// hashingReader checks the SHA-256 of the body against the signed value
+// when it reaches EOF.
+type hashingReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.body.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.hash.Sum(nil)) != h.expected {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+func (h *hashingReader) Close() error {
+	return h.body.Close()
+}
+
+// chunkedReader decodes an aws-chunked body:
+//
+//	size[;chunk-signature=sig]\r\n data \r\n ... 0[;chunk-signature=sig]\r\n [trailers] \r\n
+//
+// Each chunk signature chains on the previous one, starting from the seed
+// signature of the request. A chunk's data is handed out as it arrives and
+// its signature is checked before the next chunk header is read.
+type chunkedReader struct {
+	body   io.ReadCloser
+	r      *bufio.Reader
+	signed bool
+	sig    *Signature
+
+	prevSig   string
+	chunkSig  string
+	chunkHash hash.Hash
+	remaining int64
+	inChunk   bool
+	err       error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.remaining == 0 {
+		if c.inChunk {
+			c.err = c.finishChunk()
+			if c.err != nil {
+				return 0, c.err
+			}
+		}
+		c.err = c.nextChunk()
+		if c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.chunkHash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+// nextChunk reads a chunk header. On the final, empty chunk it also
+// consumes the trailers and returns io.EOF.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeField, params, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return ErrMalformedChunk
+	}
+
+	c.chunkSig = ""
+	if c.signed {
+		name, value, ok := strings.Cut(params, "=")
+		if !ok || strings.TrimSpace(name) != "chunk-signature" {
+			return ErrMalformedChunk
+		}
+		c.chunkSig = strings.TrimSpace(value)
+	}
+	c.chunkHash.Reset()
+	c.remaining = size
+	c.inChunk = true
+
+	if size > 0 {
+		return nil
+	}
+
+	err = c.verifyChunk()
+	if err != nil {
+		return err
+	}
+	err = c.readTrailers()
+	if err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// finishChunk consumes the CRLF that follows the chunk data and verifies the
+// chunk signature.
+func (c *chunkedReader) finishChunk() error {
+	c.inChunk = false
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return ErrMalformedChunk
+	}
+	return c.verifyChunk()
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if !c.signed {
+		return nil
+	}
+
+	stringToSign := strings.Join([]string{
+		Algorithm + "-PAYLOAD",
+		c.sig.Time.Format(TimeFormat),
+		c.sig.scope(),
+		c.prevSig,
+		emptySHA256,
+		hex.EncodeToString(c.chunkHash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.sig.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return ErrSignatureMismatch
+	}
+
+	c.prevSig = c.chunkSig
+	return nil
+}
+
+// readTrailers consumes the trailing headers up to the terminating empty
+// line and checks x-amz-trailer-signature when the payload is signed.
+func (c *chunkedReader) readTrailers() error {
+	var canonical strings.Builder
+	trailerSig := ""
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return ErrMalformedChunk
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if name == "x-amz-trailer-signature" {
+			trailerSig = value
+			continue
+		}
+		canonical.WriteString(name + ":" + value + "\n")
+	}
+
+	if !c.signed || c.sig.PayloadHash != StreamingPayloadTrailer {
+		return nil
+	}
+
+	stringToSign := strings.Join([]string{
+		Algorithm + "-TRAILER",
+		c.sig.Time.Format(TimeFormat),
+		c.sig.scope(),
+		c.prevSig,
+		sha256Hex([]byte(canonical.String())),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.sig.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(trailerSig)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", ErrMalformedChunk
+	}
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
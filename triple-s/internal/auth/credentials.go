@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadCredentials reads a CSV key file with AccessKeyId,SecretAccessKey
+// records and returns the secrets indexed by access key. A header row and
+// lines starting with # are skipped.
+func LoadCredentials(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make(map[string]string)
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "AccessKeyId" {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected AccessKeyId,SecretAccessKey", i+1)
+		}
+		accessKey := strings.TrimSpace(record[0])
+		secretKey := strings.TrimSpace(record[1])
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("line %d: empty access key or secret", i+1)
+		}
+		credentials[accessKey] = secretKey
+	}
+
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("no credentials found in %s", path)
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Presign returns rawURL with SigV4 query parameters that authorize a
+// single method on it for the given duration, starting at t. Only the host
+// header is signed and the payload is left unsigned, so the URL can be used
+// by any HTTP client.
+func Presign(method, rawURL, accessKey, secret, region string, t time.Time, expires time.Duration) (string, error) {
+	if expires < MinPresignExpiry || expires > MaxPresignExpiry {
+		return "", ErrInvalidExpires
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	sig := &Signature{
+		AccessKey:     accessKey,
+		Time:          t.UTC(),
+		Region:        region,
+		Service:       "s3",
+		SignedHeaders: []string{"host"},
+		PayloadHash:   UnsignedPayload,
+		Presigned:     true,
+		Expires:       expires,
+	}
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", Algorithm)
+	query.Set("X-Amz-Credential", accessKey+"/"+sig.scope())
+	query.Set("X-Amz-Date", sig.Time.Format(TimeFormat))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", strings.Join(sig.SignedHeaders, ";"))
+
+	// The request is only used to build the canonical form; it is never sent.
+	r, err := http.NewRequest(strings.ToUpper(method), u.Scheme+"://"+u.Host+uriEncode(u.Path, false), nil)
+	if err != nil {
+		return "", err
+	}
+	r.URL.RawQuery = canonicalQuery(query, true)
+
+	key := SigningKey(secret, sig.Time, region, sig.Service)
+	canonical := canonicalRequest(r, sig.SignedHeaders, sig.PayloadHash, true)
+	signature := hmacSHA256(key, stringToSign(sig.Time, sig.scope(), canonical))
+
+	return r.URL.String() + "&X-Amz-Signature=" + hex.EncodeToString(signature), nil
+}
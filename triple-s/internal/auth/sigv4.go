@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm = "AWS4-HMAC-SHA256"
+
+	UnsignedPayload                 = "UNSIGNED-PAYLOAD"
+	StreamingPayload                = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingPayloadTrailer         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	StreamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	TimeFormat = "20060102T150405Z"
+	DateFormat = "20060102"
+
+	// MaxClockSkew is how far the request time may differ from the server
+	// clock for header signed requests.
+	MaxClockSkew = 15 * time.Minute
+
+	// MinPresignExpiry and MaxPresignExpiry bound X-Amz-Expires, as on S3.
+	MinPresignExpiry = time.Second
+	MaxPresignExpiry = 7 * 24 * time.Hour
+
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	terminator  = "aws4_request"
+)
+
+var (
+	ErrMissingAuth           = errors.New("request is not signed")
+	ErrMalformedAuth         = errors.New("authorization is malformed")
+	ErrUnsupportedAlgorithm  = errors.New("unsupported signing algorithm")
+	ErrInvalidAccessKey      = errors.New("access key does not exist")
+	ErrSignatureMismatch     = errors.New("signature does not match")
+	ErrRequestTimeSkewed     = errors.New("request time is too far from server time")
+	ErrRequestExpired        = errors.New("presigned request has expired")
+	ErrInvalidExpires        = errors.New("X-Amz-Expires must be between 1 and 604800 seconds")
+	ErrContentSHA256Mismatch = errors.New("x-amz-content-sha256 does not match the body")
+	ErrMalformedChunk        = errors.New("malformed aws-chunked body")
+	ErrUnsignedHeaders       = errors.New("headers present in the request are not signed")
+)
+
+// Signature is a parsed and verified SigV4 signature.
+type Signature struct {
+	AccessKey     string
+	Time          time.Time
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+	PayloadHash   string
+	Presigned     bool
+	Expires       time.Duration
+
+	signingKey []byte
+}
+
+func (s *Signature) scope() string {
+	return strings.Join([]string{s.Time.Format(DateFormat), s.Region, s.Service, terminator}, "/")
+}
+
+// Verify checks the SigV4 signature of r, carried either in the
+// Authorization header or in presigned query parameters, against the known
+// credentials.
+func Verify(r *http.Request, credentials map[string]string, now time.Time) (*Signature, error) {
+	var sig *Signature
+	var err error
+
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), Algorithm+" "):
+		sig, err = parseAuthorizationHeader(r)
+	case r.URL.Query().Has("X-Amz-Algorithm"):
+		sig, err = parsePresignedQuery(r)
+	case r.Header.Get("Authorization") != "":
+		return nil, ErrUnsupportedAlgorithm
+	default:
+		return nil, ErrMissingAuth
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = checkSignedHeaders(r, sig.SignedHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := credentials[sig.AccessKey]
+	if !ok {
+		return nil, ErrInvalidAccessKey
+	}
+
+	if sig.Presigned {
+		if now.Before(sig.Time.Add(-MaxClockSkew)) {
+			return nil, ErrRequestTimeSkewed
+		}
+		if now.After(sig.Time.Add(sig.Expires)) {
+			return nil, ErrRequestExpired
+		}
+	} else {
+		skew := now.Sub(sig.Time)
+		if skew > MaxClockSkew || skew < -MaxClockSkew {
+			return nil, ErrRequestTimeSkewed
+		}
+	}
+
+	sig.signingKey = SigningKey(secret, sig.Time, sig.Region, sig.Service)
+
+	canonical := canonicalRequest(r, sig.SignedHeaders, sig.PayloadHash, sig.Presigned)
+	expected := hex.EncodeToString(hmacSHA256(sig.signingKey, stringToSign(sig.Time, sig.scope(), canonical)))
+	if !hmac.Equal([]byte(expected), []byte(sig.Signature)) {
+		return nil, ErrSignatureMismatch
+	}
+
+	return sig, nil
+}
+
+// parseAuthorizationHeader parses
+// "AWS4-HMAC-SHA256 Credential=AKID/date/region/service/aws4_request,
+// SignedHeaders=a;b, Signature=hex".
+func parseAuthorizationHeader(r *http.Request) (*Signature, error) {
+	fields := map[string]string{}
+	params := strings.TrimPrefix(r.Header.Get("Authorization"), Algorithm+" ")
+	for _, part := range strings.Split(params, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, ErrMalformedAuth
+		}
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		amzDate = r.Header.Get("Date")
+	}
+
+	sig, err := newSignature(fields["Credential"], fields["SignedHeaders"], fields["Signature"], amzDate)
+	if err != nil {
+		return nil, err
+	}
+
+	sig.PayloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.PayloadHash == "" {
+		sig.PayloadHash = UnsignedPayload
+	}
+
+	return sig, nil
+}
+
+func parsePresignedQuery(r *http.Request) (*Signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != Algorithm {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	sig, err := newSignature(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature"), query.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, err
+	}
+
+	seconds, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidExpires
+	}
+	sig.Expires = time.Duration(seconds) * time.Second
+	if sig.Expires < MinPresignExpiry || sig.Expires > MaxPresignExpiry {
+		return nil, ErrInvalidExpires
+	}
+	sig.Presigned = true
+	sig.PayloadHash = UnsignedPayload
+
+	return sig, nil
+}
+
+func newSignature(credential, signedHeaders, signature, amzDate string) (*Signature, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != terminator || signedHeaders == "" || signature == "" {
+		return nil, ErrMalformedAuth
+	}
+
+	t, err := time.Parse(TimeFormat, amzDate)
+	if err != nil {
+		t, err = http.ParseTime(amzDate)
+		if err != nil {
+			return nil, ErrMalformedAuth
+		}
+	}
+	if t.UTC().Format(DateFormat) != parts[1] {
+		return nil, ErrMalformedAuth
+	}
+
+	return &Signature{
+		AccessKey:     parts[0],
+		Time:          t.UTC(),
+		Region:        parts[2],
+		Service:       parts[3],
+		SignedHeaders: strings.Split(signedHeaders, ";"),
+		Signature:     signature,
+	}, nil
+}
+
+// checkSignedHeaders makes sure that the signature covers the host and
+// every x-amz-* header of r, so that none of them can be added or changed
+// without invalidating it.
+func checkSignedHeaders(r *http.Request, signedHeaders []string) error {
+	if !slices.Contains(signedHeaders, "host") {
+		return ErrUnsignedHeaders
+	}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") && !slices.Contains(signedHeaders, name) {
+			return ErrUnsignedHeaders
+		}
+	}
+	return nil
+}
+
+func SigningKey(secret string, t time.Time, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), t.UTC().Format(DateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, terminator)
+}
+
+func stringToSign(t time.Time, scope, canonicalRequest string) string {
+	return strings.Join([]string{
+		Algorithm,
+		t.UTC().Format(TimeFormat),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+}
+
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string, presigned bool) string {
+	headers := make([]string, 0, len(signedHeaders))
+	for _, name := range signedHeaders {
+		var value string
+		if name == "host" {
+			value = r.Host
+		} else {
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		headers = append(headers, name+":"+strings.Join(strings.Fields(value), " ")+"\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(r.URL.Query(), presigned),
+		strings.Join(headers, ""),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(query url.Values, presigned bool) string {
+	pairs := []string{}
+	for name, values := range query {
+		if presigned && name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes every byte except the RFC 3986 unreserved
+// characters, and the slash unless encodeSlash is set, as SigV4 requires.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"triple-s/internal/policy"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const xmlSchemaInstance = "http://www.w3.org/2001/XMLSchema-instance"
+
+// cannedACL reads the x-amz-acl header of a request. It sends the error
+// response itself and returns false when the ACL is unknown.
+func (h *Handler) cannedACL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	acl := r.Header.Get("x-amz-acl")
+	if !policy.ValidACL(acl) {
+		h.sendError(w, "InvalidArgument", "Unknown canned ACL: "+acl, http.StatusBadRequest)
+		return "", false
+	}
+	return acl, true
+}
+
+// objectACL reads the canned ACL an object is written with. Setting one
+// needs s3:PutObjectAcl on top of s3:PutObject.
+func (h *Handler) objectACL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	acl, ok := h.cannedACL(w, r)
+	if !ok || (acl != "" && !h.authorize(w, r, "s3:PutObjectAcl")) {
+		return "", false
+	}
+	return acl, true
+}
+
+// aclOnly rejects requests that set an ACL with grants in the body rather
+// than a canned ACL.
+func (h *Handler) aclOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("x-amz-acl") == "" {
+		h.sendError(w, "NotImplemented", "Only canned ACLs set with the x-amz-acl header are supported", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) PutBucketAcl(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:PutBucketAcl") || !h.aclOnly(w, r) {
+		return
+	}
+	acl, ok := h.cannedACL(w, r)
+	if !ok {
+		return
+	}
+
+	err := storage.PutBucketACL(h.server.Dir, bucketName, acl)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to set bucket ACL")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketAcl(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:GetBucketAcl") {
+		return
+	}
+
+	access, err := storage.GetBucketAccess(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to get bucket ACL")
+		return
+	}
+
+	h.sendXML(w, http.StatusOK, accessControlPolicy(access.Owner, access.ACL))
+}
+
+func (h *Handler) PutObjectAcl(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	versionID := r.URL.Query().Get("versionId")
+
+	if !h.authorize(w, r, "s3:PutObjectAcl") || !h.aclOnly(w, r) {
+		return
+	}
+	acl, ok := h.cannedACL(w, r)
+	if !ok || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	err := storage.PutObjectACL(h.server.Dir, bucketName, objectKey, versionID, acl)
+	if errors.Is(err, storage.ErrNoSuchKey) {
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to set object ACL")
+		return
+	}
+
+	setVersionID(w, versionID)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetObjectAcl(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	if !h.authorize(w, r, "s3:GetObjectAcl") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	access, err := storage.GetBucketAccess(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to get object ACL")
+		return
+	}
+	object, err := storage.GetObjectVersion(h.server.Dir, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrNoSuchKey) || (err == nil && object.DeleteMarker) {
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to get object ACL", http.StatusInternalServerError)
+		return
+	}
+
+	setVersionID(w, object.VersionID)
+	h.sendXML(w, http.StatusOK, accessControlPolicy(access.Owner, object.ACL))
+}
+
+// accessControlPolicy lists the grants of a canned ACL. The owner of the
+// bucket has full control of the bucket and its objects.
+func accessControlPolicy(owner, acl string) structure.AccessControlPolicy {
+	response := structure.AccessControlPolicy{
+		Owner: structure.Owner{ID: owner, DisplayName: owner},
+		Grants: []structure.Grant{{
+			Grantee:    structure.Grantee{XMLNSXSI: xmlSchemaInstance, Type: "CanonicalUser", ID: owner},
+			Permission: policy.FullControl,
+		}},
+	}
+	for _, grant := range policy.Grants(acl) {
+		response.Grants = append(response.Grants, structure.Grant{
+			Grantee:    structure.Grantee{XMLNSXSI: xmlSchemaInstance, Type: "Group", URI: grant.Group},
+			Permission: grant.Permission,
+		})
+	}
+	return response
+}
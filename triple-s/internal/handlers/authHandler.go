@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"triple-s/internal/auth"
+	"triple-s/internal/policy"
+	"triple-s/internal/storage"
+)
+
+type accessKeyContext struct{}
+
+// Authenticate verifies the AWS Signature V4 of every request before
+// passing it on with the access key it was signed with. Unsigned requests
+// are passed on as anonymous, for the handlers to authorize against bucket
+// policies and ACLs. It is a no-op when the server has no credentials.
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.server.Credentials == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sig, err := auth.Verify(r, h.server.Credentials, time.Now())
+		if errors.Is(err, auth.ErrMissingAuth) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err == nil {
+			err = auth.WrapBody(r, sig)
+		}
+		if err != nil {
+			h.sendAuthError(w, err)
+			return
+		}
+
+		if rec, ok := w.(*accessRecorder); ok {
+			rec.requester = sig.AccessKey
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessKeyContext{}, sig.AccessKey)))
+	})
+}
+
+// accessKey returns the access key a request was signed with, or an empty
+// string for anonymous requests.
+func accessKey(r *http.Request) string {
+	key, _ := r.Context().Value(accessKeyContext{}).(string)
+	return key
+}
+
+// versionActions are the actions that apply to a request with a versionId
+// in place of the actions on the current version.
+var versionActions = map[string]string{
+	"s3:GetObject":    "s3:GetObjectVersion",
+	"s3:DeleteObject": "s3:DeleteObjectVersion",
+	"s3:GetObjectAcl": "s3:GetObjectVersionAcl",
+	"s3:PutObjectAcl": "s3:PutObjectVersionAcl",
+}
+
+// ownerActions are always allowed to the owner of a bucket, so that a
+// policy cannot lock the owner out for good.
+var ownerActions = map[string]bool{
+	"s3:GetBucketPolicy":    true,
+	"s3:PutBucketPolicy":    true,
+	"s3:DeleteBucketPolicy": true,
+}
+
+// authorize checks that the requester may perform action on the bucket and
+// object of the request, sending the error response itself when it may
+// not.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, action string) bool {
+	return h.authorizeOn(w, r, action, r.PathValue("bucketName"), r.PathValue("objectKey"), r.URL.Query().Get("versionId"))
+}
+
+// authorizeOn is authorize for another object than the request's, such as
+// the source of a copy.
+func (h *Handler) authorizeOn(w http.ResponseWriter, r *http.Request, action, bucketName, objectKey, versionID string) bool {
+	allowed, err := h.allowed(r, action, bucketName, objectKey, versionID)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to check access", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		h.sendError(w, "AccessDenied", "Access Denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// allowed decides whether the requester may perform action on a bucket, or
+// on an object when objectKey is set. A Deny statement of the bucket policy
+// always wins. Otherwise the owner of the bucket may do anything, and other
+// users, including anonymous ones, what the policy or the canned ACLs of
+// the bucket and object allow. Every request is allowed when the server has
+// no credentials.
+func (h *Handler) allowed(r *http.Request, action, bucketName, objectKey, versionID string) (bool, error) {
+	if h.server.Credentials == nil {
+		return true, nil
+	}
+
+	principal := accessKey(r)
+	if bucketName == "" {
+		return principal != "", nil
+	}
+	if versioned, ok := versionActions[action]; ok && versionID != "" {
+		action = versioned
+	}
+
+	access, err := storage.GetBucketAccess(h.server.Dir, bucketName)
+	if errors.Is(err, storage.ErrNoSuchBucket) {
+		// Authenticated users may create the bucket or learn that it
+		// does not exist.
+		return principal != "", nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	owner := principal != "" && access.Owner == principal
+	if owner && ownerActions[action] {
+		return true, nil
+	}
+
+	decision := policy.Neutral
+	if access.Policy != "" {
+		bucketPolicy, err := policy.Parse([]byte(access.Policy), bucketName)
+		if err != nil {
+			return false, err
+		}
+		decision = bucketPolicy.Evaluate(policy.Request{
+			Principal: principal,
+			Action:    action,
+			Resource:  policy.ARN(bucketName, objectKey),
+			Context:   conditionContext(r),
+		})
+	}
+
+	switch {
+	case decision == policy.Denied:
+		return false, nil
+	case decision == policy.Allowed || owner:
+		return true, nil
+	case policy.BucketACLAllows(access.ACL, action, principal != ""):
+		return true, nil
+	case objectKey == "":
+		return false, nil
+	}
+
+	object, err := storage.GetObjectVersion(h.server.Dir, bucketName, objectKey, versionID)
+	if errors.Is(err, storage.ErrNoSuchKey) || errors.Is(err, storage.ErrNoSuchVersion) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return policy.ObjectACLAllows(object.ACL, action, principal != ""), nil
+}
+
+// conditionContext returns the condition keys of a request that bucket
+// policies can test.
+func conditionContext(r *http.Request) map[string]string {
+	now := time.Now()
+	keys := map[string]string{
+		"aws:currenttime":     now.UTC().Format(time.RFC3339),
+		"aws:epochtime":       strconv.FormatInt(now.Unix(), 10),
+		"aws:securetransport": strconv.FormatBool(r.TLS != nil),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		keys["aws:sourceip"] = host
+	}
+
+	headers := map[string]string{
+		"aws:useragent":                   "User-Agent",
+		"aws:referer":                     "Referer",
+		"s3:x-amz-acl":                    "x-amz-acl",
+		"s3:x-amz-copy-source":            "x-amz-copy-source",
+		"s3:x-amz-metadata-directive":     "x-amz-metadata-directive",
+		"s3:x-amz-server-side-encryption": sseHeader,
+	}
+	for key, header := range headers {
+		if value := r.Header.Get(header); value != "" {
+			keys[key] = value
+		}
+	}
+
+	query := r.URL.Query()
+	params := map[string]string{
+		"s3:prefix":    "prefix",
+		"s3:delimiter": "delimiter",
+		"s3:max-keys":  "max-keys",
+		"s3:versionid": "versionId",
+	}
+	for key, param := range params {
+		if query.Has(param) {
+			keys[key] = query.Get(param)
+		}
+	}
+
+	return keys
+}
+
+func (h *Handler) sendAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrMissingAuth):
+		h.sendError(w, "AccessDenied", "Access Denied", http.StatusForbidden)
+	case errors.Is(err, auth.ErrInvalidAccessKey):
+		h.sendError(w, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records", http.StatusForbidden)
+	case errors.Is(err, auth.ErrSignatureMismatch):
+		h.sendError(w, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided", http.StatusForbidden)
+	case errors.Is(err, auth.ErrRequestTimeSkewed):
+		h.sendError(w, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large", http.StatusForbidden)
+	case errors.Is(err, auth.ErrRequestExpired):
+		h.sendError(w, "AccessDenied", "Request has expired", http.StatusForbidden)
+	case errors.Is(err, auth.ErrInvalidExpires):
+		h.sendError(w, "AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 and 604800 seconds", http.StatusBadRequest)
+	case errors.Is(err, auth.ErrUnsupportedAlgorithm):
+		h.sendError(w, "InvalidArgument", "Only AWS4-HMAC-SHA256 signatures are supported", http.StatusBadRequest)
+	case errors.Is(err, auth.ErrMalformedChunk):
+		h.sendError(w, "InvalidRequest", "Missing or invalid x-amz-decoded-content-length", http.StatusBadRequest)
+	case errors.Is(err, auth.ErrUnsignedHeaders):
+		h.sendError(w, "AccessDenied", "There were headers present in the request which were not signed", http.StatusForbidden)
+	default:
+		h.sendError(w, "AuthorizationHeaderMalformed", "The authorization header is malformed", http.StatusBadRequest)
+	}
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"triple-s/internal/storage"
 	"triple-s/internal/structure"
@@ -17,6 +21,13 @@ func (h *Handler) PutBucket(w http.ResponseWriter, r *http.Request) {
 		h.sendError(w, "InvalidBucketName", err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, "s3:CreateBucket") {
+		return
+	}
+	acl, ok := h.cannedACL(w, r)
+	if !ok {
+		return
+	}
 
 	exists, err := storage.BucketExists(h.server.Dir, bucketName)
 	if err != nil {
@@ -28,7 +39,11 @@ func (h *Handler) PutBucket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = storage.CreateBucket(h.server.Dir, bucketName)
+	err = storage.CreateBucket(h.server.Dir, bucketName, accessKey(r), acl)
+	if errors.Is(err, storage.ErrBucketExists) {
+		h.sendError(w, "BucketAlreadyExists", "Bucket already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to create bucket", http.StatusInternalServerError)
 		return
@@ -38,23 +53,33 @@ func (h *Handler) PutBucket(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetBuckets(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeOn(w, r, "s3:ListAllMyBuckets", "", "", "") {
+		return
+	}
+
 	buckets, err := storage.ListBuckets(h.server.Dir)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to list buckets", http.StatusInternalServerError)
 		return
 	}
 
+	principal := accessKey(r)
 	response := structure.ListAllBuckets{
 		Owner: structure.Owner{
-			ID:          "",
-			DisplayName: "",
+			ID:          principal,
+			DisplayName: principal,
 		},
 		Buckets: structure.Buckets{
 			Bucket: make([]structure.Bucket, 0, len(buckets)),
 		},
 	}
 
+	// With credentials, each access key sees only the buckets it owns,
+	// except the admin key, which sees all of them.
 	for _, bucket := range buckets {
+		if h.server.Credentials != nil && principal != h.server.AdminKey && bucket.Owner != principal {
+			continue
+		}
 		response.Buckets.Bucket = append(response.Buckets.Bucket, structure.Bucket{
 			Name:         bucket.Name,
 			CreationTime: bucket.CreationTime,
@@ -67,9 +92,21 @@ func (h *Handler) GetBuckets(w http.ResponseWriter, r *http.Request) {
 	xml.NewEncoder(w).Encode(response)
 }
 
+// HeadBucket reports whether a bucket exists without listing it.
+func (h *Handler) HeadBucket(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "s3:ListBucket") || !h.checkBucket(w, r.PathValue("bucketName")) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	bucketName := r.PathValue("bucketName")
 
+	if !h.authorize(w, r, "s3:DeleteBucket") {
+		return
+	}
+
 	exists, err := storage.BucketExists(h.server.Dir, bucketName)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
@@ -91,6 +128,10 @@ func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = storage.DeleteBucket(h.server.Dir, bucketName)
+	if errors.Is(err, storage.ErrBucketNotEmpty) {
+		h.sendError(w, "BucketNotEmpty", "Bucket is not empty", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to delete bucket", http.StatusInternalServerError)
 		return
@@ -98,3 +139,144 @@ func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+const (
+	defaultMaxKeys = 1000
+
+	tokenKeyPrefix    = "k:"
+	tokenCommonPrefix = "p:"
+)
+
+func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	query := r.URL.Query()
+
+	if !h.authorize(w, r, "s3:ListBucket") {
+		return
+	}
+
+	exists, err := storage.BucketExists(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	maxKeys := defaultMaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err = strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			h.sendError(w, "InvalidArgument", "max-keys must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		if maxKeys > defaultMaxKeys {
+			maxKeys = defaultMaxKeys
+		}
+	}
+
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	startAfter := query.Get("start-after")
+	token := query.Get("continuation-token")
+
+	// The continuation token takes precedence over start-after. It records
+	// the last key or common prefix returned, so a listing resumes right
+	// after it.
+	marker, markerIsPrefix := startAfter, false
+	if query.Has("continuation-token") {
+		marker, markerIsPrefix, err = decodeContinuationToken(token)
+		if err != nil {
+			h.sendError(w, "InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := structure.ListBucketResult{
+		Name:              bucketName,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        startAfter,
+		Contents:          []structure.ObjectContents{},
+		CommonPrefixes:    []structure.CommonPrefix{},
+	}
+
+	lastEntry, lastIsPrefix := "", false
+	err = storage.WalkObjects(h.server.Dir, bucketName, prefix, marker, func(object structure.Object) bool {
+		key := object.ObjectKey
+		if markerIsPrefix && strings.HasPrefix(key, marker) {
+			return true
+		}
+
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if commonPrefix != "" && lastIsPrefix && commonPrefix == lastEntry {
+			return true
+		}
+
+		if response.KeyCount == maxKeys {
+			response.IsTruncated = true
+			return false
+		}
+
+		if commonPrefix != "" {
+			response.CommonPrefixes = append(response.CommonPrefixes, structure.CommonPrefix{Prefix: commonPrefix})
+			lastEntry, lastIsPrefix = commonPrefix, true
+		} else {
+			response.Contents = append(response.Contents, structure.ObjectContents{
+				Key:          key,
+				LastModified: object.LastModified,
+				ETag:         quoteETagIfSet(object.ETag),
+				Size:         object.Size,
+				StorageClass: "STANDARD",
+			})
+			lastEntry, lastIsPrefix = key, false
+		}
+		response.KeyCount++
+		return true
+	})
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to list objects", http.StatusInternalServerError)
+		return
+	}
+
+	if response.IsTruncated {
+		response.NextContinuationToken = encodeContinuationToken(lastEntry, lastIsPrefix)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
+
+func encodeContinuationToken(entry string, isPrefix bool) string {
+	kind := tokenKeyPrefix
+	if isPrefix {
+		kind = tokenCommonPrefix
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + entry))
+}
+
+func decodeContinuationToken(token string) (marker string, isPrefix bool, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", false, err
+	}
+	value := string(raw)
+	switch {
+	case strings.HasPrefix(value, tokenKeyPrefix):
+		return strings.TrimPrefix(value, tokenKeyPrefix), false, nil
+	case strings.HasPrefix(value, tokenCommonPrefix):
+		return strings.TrimPrefix(value, tokenCommonPrefix), true, nil
+	}
+	return "", false, errors.New("unknown continuation token kind")
+}
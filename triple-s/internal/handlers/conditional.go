@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"triple-s/internal/auth"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+var errInvalidDigest = errors.New("invalid Content-MD5")
+
+// checkPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since against the current object, which is nil when it
+// does not exist. It returns 0 when the request may proceed, otherwise the
+// status to answer with: 304 for reads that are not modified and 412 for
+// failed preconditions. The order of evaluation follows RFC 7232.
+func checkPreconditions(r *http.Request, object *structure.Object) int {
+	isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if object == nil || !etagMatches(ifMatch, object.ETag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPTime(r.Header.Get("If-Unmodified-Since")); ok && object != nil {
+		if object.LastModified.Truncate(time.Second).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if object != nil && etagMatches(ifNoneMatch, object.ETag) {
+			if isRead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPTime(r.Header.Get("If-Modified-Since")); ok && isRead && object != nil {
+		if !object.LastModified.Truncate(time.Second).After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// checkCopyPreconditions evaluates the x-amz-copy-source-if-* headers
+// against the source of a copy and reports whether the copy may proceed.
+// As on S3, a matching ETag takes precedence over a failed modification
+// date check, and so does a non-matching ETag for If-None-Match.
+func checkCopyPreconditions(r *http.Request, source *structure.Object) bool {
+	modified := source.LastModified.Truncate(time.Second)
+
+	if ifMatch := r.Header.Get("x-amz-copy-source-if-match"); ifMatch != "" {
+		if !etagMatches(ifMatch, source.ETag) {
+			return false
+		}
+	} else if since, ok := parseHTTPTime(r.Header.Get("x-amz-copy-source-if-unmodified-since")); ok {
+		if modified.After(since) {
+			return false
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("x-amz-copy-source-if-none-match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, source.ETag) {
+			return false
+		}
+	} else if since, ok := parseHTTPTime(r.Header.Get("x-amz-copy-source-if-modified-since")); ok {
+		if !modified.After(since) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// etagMatches reports whether a comma separated If-Match or If-None-Match
+// header value lists etag or is "*". Weak validators compare by value.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if etag != "" && strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+// quoteETagIfSet leaves the ETag empty for objects stored before ETags were
+// recorded.
+func quoteETagIfSet(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	return quoteETag(etag)
+}
+
+// sendPreconditionResult answers a request that failed checkPreconditions.
+func (h *Handler) sendPreconditionResult(w http.ResponseWriter, status int, object *structure.Object) {
+	if status == http.StatusNotModified {
+		if object != nil {
+			setValidators(w, object)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+}
+
+func setValidators(w http.ResponseWriter, object *structure.Object) {
+	if object.ETag != "" {
+		w.Header().Set("ETag", quoteETag(object.ETag))
+	}
+	w.Header().Set("Last-Modified", object.LastModified.UTC().Format(http.TimeFormat))
+	setVersionID(w, object.VersionID)
+}
+
+// contentMD5 decodes the Content-MD5 header. It returns nil when the header
+// is absent.
+func contentMD5(r *http.Request) ([]byte, error) {
+	value := r.Header.Get("Content-MD5")
+	if value == "" {
+		return nil, nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sum) != md5.Size {
+		return nil, errInvalidDigest
+	}
+	return sum, nil
+}
+
+// sendStoreError maps errors from writing a request body or reading an
+// encrypted object to S3 errors.
+func (h *Handler) sendStoreError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, storage.ErrIncompleteBody):
+		h.sendError(w, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrNoSuchBucket):
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+	case errors.Is(err, storage.ErrBadDigest):
+		h.sendError(w, "BadDigest", "The Content-MD5 you specified did not match what we received", http.StatusBadRequest)
+	case errors.Is(err, auth.ErrContentSHA256Mismatch):
+		h.sendError(w, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed", http.StatusBadRequest)
+	case errors.Is(err, auth.ErrSignatureMismatch):
+		h.sendError(w, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided", http.StatusForbidden)
+	case errors.Is(err, auth.ErrMalformedChunk):
+		h.sendError(w, "IncompleteBody", "The aws-chunked body is malformed", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrEncryptionNotEnabled):
+		h.sendError(w, "InvalidRequest", "Server-side encryption is not enabled on this server", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrCustomerKeyRequired):
+		h.sendError(w, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrNotCustomerEncrypted):
+		h.sendError(w, "InvalidRequest", "The encryption parameters are not applicable to this object", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrCustomerKeyMismatch):
+		h.sendError(w, "AccessDenied", "Access Denied", http.StatusForbidden)
+	default:
+		h.sendError(w, "InternalError", message, http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) sendInvalidDigest(w http.ResponseWriter) {
+	h.sendError(w, "InvalidDigest", "The Content-MD5 you specified is not valid", http.StatusBadRequest)
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"triple-s/internal/notify"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+// copySource is the object named by an x-amz-copy-source header.
+type copySource struct {
+	bucket    string
+	key       string
+	versionID string
+	// customerKey is the key the source is encrypted with, from the
+	// x-amz-copy-source-server-side-encryption-customer-* headers.
+	customerKey []byte
+}
+
+// parseCopySource parses a copy source of the form
+// [/]bucket/key[?versionId=id], where bucket and key are URL-encoded.
+func parseCopySource(header string) (copySource, bool) {
+	path, rawQuery, _ := strings.Cut(header, "?")
+
+	path, err := url.PathUnescape(path)
+	if err != nil {
+		return copySource{}, false
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok || bucket == "" || key == "" {
+		return copySource{}, false
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return copySource{}, false
+	}
+
+	return copySource{bucket: bucket, key: key, versionID: query.Get("versionId")}, true
+}
+
+// CopyObject handles a PUT carrying x-amz-copy-source. The copy keeps the
+// headers of the source unless x-amz-metadata-directive is REPLACE, in
+// which case they are taken from the request like for PutObject. The
+// x-amz-copy-source-if-* headers make the copy conditional on the source.
+// The copy is encrypted as requested, like for PutObject, whatever the
+// encryption of the source.
+func (h *Handler) CopyObject(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	if !h.checkObjectKey(w, objectKey) || !h.authorize(w, r, "s3:PutObject") {
+		return
+	}
+	acl, ok := h.objectACL(w, r)
+	if !ok {
+		return
+	}
+
+	src, ok := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	if !ok {
+		h.sendError(w, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey", http.StatusBadRequest)
+		return
+	}
+	if !h.authorizeOn(w, r, "s3:GetObject", src.bucket, src.key, src.versionID) {
+		return
+	}
+
+	directive := r.Header.Get("x-amz-metadata-directive")
+	if directive != "" && directive != "COPY" && directive != "REPLACE" {
+		h.sendError(w, "InvalidArgument", "Unknown metadata directive", http.StatusBadRequest)
+		return
+	}
+	replace := directive == "REPLACE"
+
+	src.customerKey, ok = h.customerKey(w, r, copySourceSSECustomerPrefix)
+	if !ok {
+		return
+	}
+	sse, ok := h.requestSSE(w, r)
+	if !ok {
+		return
+	}
+	encrypt := sse.S3 || sse.CustomerKey != nil
+
+	if src.bucket == bucketName && src.key == objectKey && src.versionID == "" && !replace && !encrypt {
+		h.sendError(w, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes", http.StatusBadRequest)
+		return
+	}
+
+	headers, err := objectHeaders(r)
+	if err != nil {
+		h.sendMetadataTooLarge(w)
+		return
+	}
+
+	if !h.checkBucket(w, bucketName) || !h.checkBucket(w, src.bucket) {
+		return
+	}
+
+	data, source, ok := h.openCopySource(w, r, src)
+	if !ok {
+		return
+	}
+	defer data.Close()
+	if !replace {
+		headers = source.ObjectHeaders
+	}
+
+	object := structure.Object{
+		ObjectKey:     objectKey,
+		LastModified:  time.Now(),
+		ObjectHeaders: headers,
+		ACL:           acl,
+	}
+
+	object, err = storage.CopyObject(h.server.Dir, data, bucketName, object, sse)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to copy object")
+		return
+	}
+	h.publish(r, notify.ObjectCreatedCopy, bucketName, object)
+
+	if source.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", source.VersionID)
+	}
+	setVersionID(w, object.VersionID)
+	setEncryptionHeaders(w, object.Encryption)
+	h.sendXML(w, http.StatusOK, structure.CopyObjectResult{
+		LastModified: object.LastModified,
+		ETag:         quoteETag(object.ETag),
+	})
+}
+
+// openCopySource opens the source of a copy and evaluates the copy
+// preconditions on the version it opened, so that a concurrent write
+// cannot swap in data the preconditions were not checked against. It sends
+// the error response itself when the source cannot be copied; otherwise
+// the caller closes the data.
+func (h *Handler) openCopySource(w http.ResponseWriter, r *http.Request, src copySource) (storage.ObjectData, *structure.Object, bool) {
+	data, source, err := storage.OpenObject(h.server.Dir, src.bucket, src.key, src.versionID, src.customerKey)
+	if source != nil && source.DeleteMarker {
+		if src.versionID != "" {
+			h.sendError(w, "InvalidRequest", "The source of a copy request may not specifically refer to a delete marker by version id", http.StatusBadRequest)
+			return nil, nil, false
+		}
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if errors.Is(err, storage.ErrNoSuchKey) {
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to open copy source")
+		return nil, nil, false
+	}
+
+	if !checkCopyPreconditions(r, source) {
+		data.Close()
+		h.sendPreconditionResult(w, http.StatusPreconditionFailed, source)
+		return nil, nil, false
+	}
+
+	return data, source, true
+}
+
+// UploadPartCopy handles an UploadPart carrying x-amz-copy-source, taking
+// the part from an existing object, or from the bytes of it selected by
+// x-amz-copy-source-range.
+func (h *Handler) UploadPartCopy(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	query := r.URL.Query()
+
+	if !h.authorize(w, r, "s3:PutObject") {
+		return
+	}
+
+	partNumber, ok := h.partNumber(w, query.Get("partNumber"))
+	if !ok {
+		return
+	}
+
+	src, ok := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	if !ok {
+		h.sendError(w, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey", http.StatusBadRequest)
+		return
+	}
+	if !h.authorizeOn(w, r, "s3:GetObject", src.bucket, src.key, src.versionID) {
+		return
+	}
+
+	src.customerKey, ok = h.customerKey(w, r, copySourceSSECustomerPrefix)
+	if !ok {
+		return
+	}
+	customerKey, ok := h.customerKey(w, r, sseCustomerPrefix)
+	if !ok {
+		return
+	}
+
+	if !h.checkBucket(w, bucketName) || !h.checkBucket(w, src.bucket) {
+		return
+	}
+	upload, ok := h.loadUpload(w, bucketName, objectKey, query.Get("uploadId"))
+	if !ok {
+		return
+	}
+
+	data, source, ok := h.openCopySource(w, r, src)
+	if !ok {
+		return
+	}
+	defer data.Close()
+
+	offset, length := int64(0), source.Size
+	if header := r.Header.Get("x-amz-copy-source-range"); header != "" {
+		br, err := parseCopyRange(header, source.Size)
+		if err != nil {
+			h.sendError(w, "InvalidArgument", fmt.Sprintf("Range specified is not valid for source object of size: %d", source.Size), http.StatusBadRequest)
+			return
+		}
+		offset, length = br.start, br.length()
+	}
+
+	etag, err := storage.UploadPartCopy(h.server.Dir, upload.UploadID, partNumber, data, offset, length, customerKey)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to copy part")
+		return
+	}
+
+	if source.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", source.VersionID)
+	}
+	setEncryptionHeaders(w, upload.Encryption)
+	h.sendXML(w, http.StatusOK, structure.CopyPartResult{
+		LastModified: time.Now(),
+		ETag:         quoteETag(etag),
+	})
+}
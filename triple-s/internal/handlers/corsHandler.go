@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const (
+	maxCORSRules  = 100
+	maxCORSRuleID = 255
+)
+
+// corsMethods are the methods CORS rules may allow.
+var corsMethods = []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodHead}
+
+func (h *Handler) PutBucketCors(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:PutBucketCORS") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	var config structure.CORSConfiguration
+	if !h.decodeXMLBody(w, r, &config) {
+		return
+	}
+	if len(config.Rules) == 0 || len(config.Rules) > maxCORSRules {
+		h.sendMalformedXML(w)
+		return
+	}
+
+	rules := make([]storage.CORSRule, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 ||
+			(rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0) {
+			h.sendMalformedXML(w)
+			return
+		}
+		if len(rule.ID) > maxCORSRuleID {
+			h.sendError(w, "InvalidArgument", "ID length should not exceed allowed limit of 255", http.StatusBadRequest)
+			return
+		}
+		for _, method := range rule.AllowedMethods {
+			if !slices.Contains(corsMethods, method) {
+				h.sendError(w, "InvalidRequest", "Found unsupported HTTP method in CORS config. Unsupported method is "+method, http.StatusBadRequest)
+				return
+			}
+		}
+		for _, origin := range rule.AllowedOrigins {
+			if strings.Count(origin, "*") > 1 {
+				h.sendError(w, "InvalidRequest", `AllowedOrigin "`+origin+`" can not have more than one wildcard.`, http.StatusBadRequest)
+				return
+			}
+		}
+		for _, header := range rule.AllowedHeaders {
+			if strings.Count(header, "*") > 1 {
+				h.sendError(w, "InvalidRequest", `AllowedHeader "`+header+`" can not have more than one wildcard.`, http.StatusBadRequest)
+				return
+			}
+		}
+
+		converted := storage.CORSRule{
+			ID:             rule.ID,
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  -1,
+		}
+		if rule.MaxAgeSeconds != nil {
+			converted.MaxAgeSeconds = *rule.MaxAgeSeconds
+		}
+		rules = append(rules, converted)
+	}
+
+	err := storage.PutBucketCors(h.server.Dir, bucketName, rules)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to set bucket CORS configuration")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketCors(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:GetBucketCORS") {
+		return
+	}
+
+	rules, err := storage.GetBucketCors(h.server.Dir, bucketName)
+	if errors.Is(err, storage.ErrNoSuchCORSConfiguration) {
+		h.sendError(w, "NoSuchCORSConfiguration", "The CORS configuration does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to get bucket CORS configuration")
+		return
+	}
+
+	config := structure.CORSConfiguration{}
+	for _, rule := range rules {
+		converted := structure.CORSRule{
+			ID:             rule.ID,
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			ExposeHeaders:  rule.ExposeHeaders,
+		}
+		if rule.MaxAgeSeconds >= 0 {
+			converted.MaxAgeSeconds = &rule.MaxAgeSeconds
+		}
+		config.Rules = append(config.Rules, converted)
+	}
+
+	h.sendXML(w, http.StatusOK, config)
+}
+
+func (h *Handler) DeleteBucketCors(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	// Deleting the configuration is allowed by s3:PutBucketCORS, as in S3.
+	if !h.authorize(w, r, "s3:PutBucketCORS") {
+		return
+	}
+
+	err := storage.DeleteBucketCors(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to delete bucket CORS configuration")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreflightCors answers the OPTIONS request a browser sends before a cross
+// origin request, with the methods and headers the first matching CORS
+// rule of the bucket allows. Preflight requests are never signed, so they
+// are not authorized.
+func (h *Handler) PreflightCors(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+
+	if origin == "" {
+		h.sendError(w, "BadRequest", "Insufficient information. Origin request header needed.", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(corsMethods, method) {
+		h.sendError(w, "BadRequest", "Invalid Access-Control-Request-Method: "+method, http.StatusBadRequest)
+		return
+	}
+
+	var headers []string
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, strings.ToLower(header))
+		}
+	}
+
+	rules, err := storage.GetBucketCors(h.server.Dir, bucketName)
+	if errors.Is(err, storage.ErrNoSuchCORSConfiguration) {
+		h.sendError(w, "AccessForbidden", "CORSResponse: CORS is not enabled for this bucket.", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to get bucket CORS configuration")
+		return
+	}
+
+	w.Header().Set("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+	rule := matchCORSRule(rules, origin, method, headers)
+	if rule == nil {
+		h.sendError(w, "AccessForbidden", "CORSResponse: This CORS request is not allowed. This is usually because the evaluation of Origin, request method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.", http.StatusForbidden)
+		return
+	}
+
+	setCORSHeaders(w, rule, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if rule.MaxAgeSeconds >= 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAgeSeconds))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// CORS adds the Access-Control-* headers of the matching CORS rule of the
+// bucket to requests sent by browsers from other origins, including those
+// that fail, so that scripts can read the error. Preflight requests are
+// left to PreflightCors.
+func (h *Handler) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		bucketName, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if origin == "" || bucketName == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rules, err := storage.GetBucketCors(h.server.Dir, bucketName)
+		if err == nil {
+			w.Header().Set("Vary", "Origin")
+			if rule := matchCORSRule(rules, origin, r.Method, nil); rule != nil {
+				setCORSHeaders(w, rule, origin)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matchCORSRule returns the first rule that allows method from origin with
+// the given request headers, or nil.
+func matchCORSRule(rules []storage.CORSRule, origin, method string, headers []string) *storage.CORSRule {
+	for i, rule := range rules {
+		if !slices.Contains(rule.AllowedMethods, method) {
+			continue
+		}
+		if !slices.ContainsFunc(rule.AllowedOrigins, func(allowed string) bool {
+			return matchWildcard(allowed, origin)
+		}) {
+			continue
+		}
+		if !allHeadersAllowed(rule.AllowedHeaders, headers) {
+			continue
+		}
+		return &rules[i]
+	}
+	return nil
+}
+
+func allHeadersAllowed(allowed, headers []string) bool {
+	for _, header := range headers {
+		if !slices.ContainsFunc(allowed, func(allowed string) bool {
+			return matchWildcard(strings.ToLower(allowed), header)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchWildcard reports whether value matches pattern, in which a single *
+// stands for any sequence of characters.
+func matchWildcard(pattern, value string) bool {
+	prefix, suffix, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == value
+	}
+	return len(value) >= len(prefix)+len(suffix) && strings.HasPrefix(value, prefix) && strings.HasSuffix(value, suffix)
+}
+
+// setCORSHeaders allows origin to read the response. Rules open to every
+// origin allow it as *, without credentials.
+func setCORSHeaders(w http.ResponseWriter, rule *storage.CORSRule, origin string) {
+	if slices.Contains(rule.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(rule.ExposeHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+}
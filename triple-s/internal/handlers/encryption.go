@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const (
+	sseHeader = "x-amz-server-side-encryption"
+	// The SSE-C headers of the object a request reads or writes, and of the
+	// source of a copy, are the algorithm, key and key-MD5 headers under
+	// these prefixes.
+	sseCustomerPrefix           = "x-amz-server-side-encryption-customer-"
+	copySourceSSECustomerPrefix = "x-amz-copy-source-server-side-encryption-customer-"
+)
+
+// requestSSE reads the encryption a write request asks for. It sends the
+// error response itself and returns false when the headers are invalid.
+func (h *Handler) requestSSE(w http.ResponseWriter, r *http.Request) (storage.SSE, bool) {
+	customerKey, ok := h.customerKey(w, r, sseCustomerPrefix)
+	if !ok {
+		return storage.SSE{}, false
+	}
+
+	sse := storage.SSE{CustomerKey: customerKey}
+	switch r.Header.Get(sseHeader) {
+	case "":
+	case storage.SSEAlgorithm:
+		if customerKey != nil {
+			h.sendError(w, "InvalidArgument", "Server Side Encryption with Customer provided key is incompatible with the encryption method specified", http.StatusBadRequest)
+			return storage.SSE{}, false
+		}
+		sse.S3 = true
+	default:
+		h.sendError(w, "InvalidArgument", "The encryption method specified is not supported", http.StatusBadRequest)
+		return storage.SSE{}, false
+	}
+	return sse, true
+}
+
+// customerKey reads the SSE-C headers starting with prefix and returns the
+// key, or nil if there are none. It sends the error response itself and
+// returns false when the headers are invalid.
+func (h *Handler) customerKey(w http.ResponseWriter, r *http.Request, prefix string) ([]byte, bool) {
+	algorithm := r.Header.Get(prefix + "algorithm")
+	encodedKey := r.Header.Get(prefix + "key")
+	keyMD5 := r.Header.Get(prefix + "key-MD5")
+	if algorithm == "" && encodedKey == "" && keyMD5 == "" {
+		return nil, true
+	}
+
+	if algorithm != storage.SSEAlgorithm {
+		h.sendError(w, "InvalidArgument", "Requests specifying Server Side Encryption with Customer provided keys must provide a valid encryption algorithm", http.StatusBadRequest)
+		return nil, false
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != 32 {
+		h.sendError(w, "InvalidArgument", "The secret key was invalid for the specified algorithm", http.StatusBadRequest)
+		return nil, false
+	}
+	if keyMD5 != storage.CustomerKeyMD5(key) {
+		h.sendError(w, "InvalidArgument", "The calculated MD5 hash of the key did not match the hash that was provided", http.StatusBadRequest)
+		return nil, false
+	}
+	return key, true
+}
+
+// setEncryptionHeaders reports how an object is encrypted at rest.
+func setEncryptionHeaders(w http.ResponseWriter, encryption structure.Encryption) {
+	if encryption.SSE != "" {
+		w.Header().Set(sseHeader, encryption.SSE)
+	}
+	if encryption.SSECustomerKeyMD5 != "" {
+		w.Header().Set(sseCustomerPrefix+"algorithm", storage.SSEAlgorithm)
+		w.Header().Set(sseCustomerPrefix+"key-MD5", encryption.SSECustomerKeyMD5)
+	}
+}
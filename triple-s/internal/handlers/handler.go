@@ -1,12 +1,22 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/md5"
 	"encoding/xml"
+	"errors"
+	"io"
 	"net/http"
 
+	"triple-s/internal/storage"
 	"triple-s/internal/structure"
+	v "triple-s/internal/validator"
 )
 
+// maxXMLBodySize bounds XML request bodies, which are read in full to check
+// their Content-MD5.
+const maxXMLBodySize = 2 << 20
+
 type Handler struct {
 	server *structure.Server
 }
@@ -18,6 +28,10 @@ func NewHandler(server *structure.Server) *Handler {
 }
 
 func (h *Handler) sendError(w http.ResponseWriter, code, message string, status int) {
+	if rec, ok := w.(*accessRecorder); ok {
+		rec.errorCode = code
+	}
+
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(status)
 
@@ -28,3 +42,81 @@ func (h *Handler) sendError(w http.ResponseWriter, code, message string, status
 
 	xml.NewEncoder(w).Encode(errorResp)
 }
+
+func (h *Handler) sendXML(w http.ResponseWriter, status int, response any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	xml.NewEncoder(w).Encode(response)
+}
+
+// checkBucket reports whether the bucket exists, sending the error response
+// itself when it does not.
+func (h *Handler) checkBucket(w http.ResponseWriter, bucketName string) bool {
+	exists, err := storage.BucketExists(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// checkObjectKey reports whether an object can be written under key,
+// sending the error response itself when it cannot.
+func (h *Handler) checkObjectKey(w http.ResponseWriter, key string) bool {
+	err := v.ValidateObjectKey(key)
+	if errors.Is(err, v.ErrObjectKeyTooLong) {
+		h.sendError(w, "KeyTooLongError", "Your key is too long", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		h.sendError(w, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// decodeXMLBody reads an XML request body into v, verifying its Content-MD5
+// when given. It sends the error response itself and returns false when the
+// body is unusable.
+func (h *Handler) decodeXMLBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	expectedMD5, err := contentMD5(r)
+	if err != nil {
+		h.sendInvalidDigest(w)
+		return false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxXMLBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.sendError(w, "MaxMessageLengthExceeded", "Your request was too big", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to read request body")
+		return false
+	}
+
+	if expectedMD5 != nil {
+		sum := md5.Sum(body)
+		if !bytes.Equal(sum[:], expectedMD5) {
+			h.sendError(w, "BadDigest", "The Content-MD5 you specified did not match what we received", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	err = xml.Unmarshal(body, v)
+	if err != nil {
+		h.sendMalformedXML(w)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) sendMalformedXML(w http.ResponseWriter) {
+	h.sendError(w, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const (
+	maxLifecycleRules  = 1000
+	maxLifecycleRuleID = 255
+)
+
+func (h *Handler) PutBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:PutLifecycleConfiguration") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	var config structure.LifecycleConfiguration
+	if !h.decodeXMLBody(w, r, &config) {
+		return
+	}
+	if len(config.Rules) == 0 || len(config.Rules) > maxLifecycleRules {
+		h.sendMalformedXML(w)
+		return
+	}
+
+	rules := make([]storage.LifecycleRule, 0, len(config.Rules))
+	ids := map[string]bool{}
+	for _, rule := range config.Rules {
+		if rule.Status != "Enabled" && rule.Status != "Disabled" {
+			h.sendMalformedXML(w)
+			return
+		}
+		if rule.Prefix != nil && rule.Filter != nil {
+			h.sendMalformedXML(w)
+			return
+		}
+		if len(rule.ID) > maxLifecycleRuleID {
+			h.sendError(w, "InvalidArgument", "ID length should not exceed allowed limit of 255", http.StatusBadRequest)
+			return
+		}
+		if rule.ID != "" && ids[rule.ID] {
+			h.sendError(w, "InvalidArgument", "Rule ID must be unique. Found same ID for more than one rule", http.StatusBadRequest)
+			return
+		}
+		ids[rule.ID] = true
+
+		converted := storage.LifecycleRule{
+			ID:      rule.ID,
+			Enabled: rule.Status == "Enabled",
+		}
+		switch {
+		case rule.Prefix != nil:
+			converted.Prefix = *rule.Prefix
+		case rule.Filter != nil:
+			converted.Prefix = rule.Filter.Prefix
+		}
+		if rule.Expiration != nil {
+			converted.ExpirationDays = rule.Expiration.Days
+		}
+		if rule.NoncurrentVersionExpiration != nil {
+			converted.NoncurrentDays = rule.NoncurrentVersionExpiration.NoncurrentDays
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			converted.AbortUploadDays = rule.AbortIncompleteMultipartUpload.DaysAfterInitiation
+		}
+
+		if converted.ExpirationDays < 0 || converted.NoncurrentDays < 0 || converted.AbortUploadDays < 0 ||
+			(rule.Expiration != nil && converted.ExpirationDays == 0) ||
+			(rule.NoncurrentVersionExpiration != nil && converted.NoncurrentDays == 0) ||
+			(rule.AbortIncompleteMultipartUpload != nil && converted.AbortUploadDays == 0) {
+			h.sendError(w, "InvalidArgument", "The number of days in a lifecycle action must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		if converted.ExpirationDays == 0 && converted.NoncurrentDays == 0 && converted.AbortUploadDays == 0 {
+			h.sendError(w, "InvalidRequest", "At least one action needs to be specified in a rule", http.StatusBadRequest)
+			return
+		}
+
+		rules = append(rules, converted)
+	}
+
+	err := storage.PutBucketLifecycle(h.server.Dir, bucketName, rules)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to set bucket lifecycle configuration")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:GetLifecycleConfiguration") {
+		return
+	}
+
+	rules, err := storage.GetBucketLifecycle(h.server.Dir, bucketName)
+	if errors.Is(err, storage.ErrNoSuchBucket) {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrNoSuchLifecycleConfiguration) {
+		h.sendError(w, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to get bucket lifecycle configuration", http.StatusInternalServerError)
+		return
+	}
+
+	config := structure.LifecycleConfiguration{}
+	for _, rule := range rules {
+		converted := structure.LifecycleRule{
+			ID:     rule.ID,
+			Status: "Disabled",
+			Filter: &structure.LifecycleFilter{Prefix: rule.Prefix},
+		}
+		if rule.Enabled {
+			converted.Status = "Enabled"
+		}
+		if rule.ExpirationDays > 0 {
+			converted.Expiration = &structure.LifecycleExpiration{Days: rule.ExpirationDays}
+		}
+		if rule.NoncurrentDays > 0 {
+			converted.NoncurrentVersionExpiration = &structure.NoncurrentVersionExpiration{NoncurrentDays: rule.NoncurrentDays}
+		}
+		if rule.AbortUploadDays > 0 {
+			converted.AbortIncompleteMultipartUpload = &structure.AbortIncompleteMultipartUpload{DaysAfterInitiation: rule.AbortUploadDays}
+		}
+		config.Rules = append(config.Rules, converted)
+	}
+
+	h.sendXML(w, http.StatusOK, config)
+}
+
+func (h *Handler) DeleteBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	// Deleting the configuration is allowed by s3:PutLifecycleConfiguration,
+	// as in S3.
+	if !h.authorize(w, r, "s3:PutLifecycleConfiguration") {
+		return
+	}
+
+	err := storage.DeleteBucketLifecycle(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to delete bucket lifecycle configuration")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"triple-s/internal/structure"
+)
+
+const (
+	userMetadataPrefix = "X-Amz-Meta-"
+	// maxUserMetadataSize is the limit S3 puts on the summed length of the
+	// names and values of the x-amz-meta-* headers.
+	maxUserMetadataSize = 2 << 10
+)
+
+var errMetadataTooLarge = errors.New("user metadata too large")
+
+// objectHeaders collects the headers of a write request that are stored
+// with the object.
+func objectHeaders(r *http.Request) (structure.ObjectHeaders, error) {
+	headers := structure.ObjectHeaders{
+		ContentType:        r.Header.Get("Content-Type"),
+		ContentEncoding:    r.Header.Get("Content-Encoding"),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		ContentLanguage:    r.Header.Get("Content-Language"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+		Expires:            r.Header.Get("Expires"),
+	}
+	if headers.ContentType == "" {
+		headers.ContentType = "application/octet-stream"
+	}
+
+	size := 0
+	for name, values := range r.Header {
+		if !strings.HasPrefix(name, userMetadataPrefix) {
+			continue
+		}
+		name = strings.ToLower(strings.TrimPrefix(name, userMetadataPrefix))
+		value := strings.Join(values, ",")
+
+		size += len(name) + len(value)
+		if size > maxUserMetadataSize {
+			return structure.ObjectHeaders{}, errMetadataTooLarge
+		}
+
+		if headers.Metadata == nil {
+			headers.Metadata = map[string]string{}
+		}
+		headers.Metadata[name] = value
+	}
+
+	return headers, nil
+}
+
+// setObjectHeaders writes the stored headers of an object to a GET or HEAD
+// response.
+func setObjectHeaders(w http.ResponseWriter, object *structure.Object) {
+	w.Header().Set("Content-Type", object.ContentType)
+
+	optional := []struct{ name, value string }{
+		{"Content-Encoding", object.ContentEncoding},
+		{"Content-Disposition", object.ContentDisposition},
+		{"Content-Language", object.ContentLanguage},
+		{"Cache-Control", object.CacheControl},
+		{"Expires", object.Expires},
+	}
+	for _, header := range optional {
+		if header.value != "" {
+			w.Header().Set(header.name, header.value)
+		}
+	}
+
+	for name, value := range object.Metadata {
+		w.Header().Set(userMetadataPrefix+name, value)
+	}
+}
+
+func (h *Handler) sendMetadataTooLarge(w http.ResponseWriter) {
+	h.sendError(w, "MetadataTooLarge", "Your metadata headers exceed the maximum allowed metadata size", http.StatusBadRequest)
+}
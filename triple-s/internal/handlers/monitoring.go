@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"triple-s/internal/metrics"
+	"triple-s/internal/storage"
+)
+
+// accessEntry is a line of the access log. Its fields follow the S3 server
+// access log format.
+type accessEntry struct {
+	Time          string `json:"time"`
+	RequestID     string `json:"requestId"`
+	RemoteIP      string `json:"remoteIp"`
+	Requester     string `json:"requester"`
+	Operation     string `json:"operation"`
+	Bucket        string `json:"bucket,omitempty"`
+	Key           string `json:"key,omitempty"`
+	RequestURI    string `json:"requestUri"`
+	HTTPStatus    int    `json:"httpStatus"`
+	ErrorCode     string `json:"errorCode,omitempty"`
+	BytesSent     int64  `json:"bytesSent"`
+	BytesReceived int64  `json:"bytesReceived"`
+	TotalTimeMs   int64  `json:"totalTimeMs"`
+	Referer       string `json:"referer,omitempty"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	VersionID     string `json:"versionId,omitempty"`
+}
+
+// accessRecorder captures what the access log and metrics need from a
+// response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status    int
+	bytesSent int64
+	// errorCode is the S3 error code sent by sendError.
+	errorCode string
+	// requester is the access key the request was signed with.
+	requester string
+}
+
+func (rec *accessRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *accessRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytesSent += int64(n)
+	return n, err
+}
+
+func (rec *accessRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// countingReader counts the bytes of a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// LogRequests gives every request an x-amz-request-id, records it in the
+// request metrics and writes it to the access log, if any.
+func (h *Handler) LogRequests(next http.Handler, accessLog *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := newRequestID()
+		w.Header().Set("x-amz-request-id", requestID)
+
+		rec := &accessRecorder{ResponseWriter: w}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		operation := operationName(r)
+		metrics.ObserveRequest(operation, rec.status, elapsed, body.n, rec.bytesSent)
+
+		if accessLog == nil {
+			return
+		}
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		entry := accessEntry{
+			Time:          start.UTC().Format(time.RFC3339Nano),
+			RequestID:     requestID,
+			RemoteIP:      r.RemoteAddr,
+			Requester:     rec.requester,
+			Operation:     operation,
+			Bucket:        bucket,
+			Key:           key,
+			RequestURI:    fmt.Sprintf("%s %s %s", r.Method, r.RequestURI, r.Proto),
+			HTTPStatus:    rec.status,
+			ErrorCode:     rec.errorCode,
+			BytesSent:     rec.bytesSent,
+			BytesReceived: body.n,
+			TotalTimeMs:   elapsed.Milliseconds(),
+			Referer:       r.Referer(),
+			UserAgent:     r.UserAgent(),
+			VersionID:     r.URL.Query().Get("versionId"),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			entry.RemoteIP = host
+		}
+		if entry.Requester == "" {
+			entry.Requester = "-"
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Failed to write access log: %v", err)
+			return
+		}
+		accessLog.Print(string(line))
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return fmt.Sprintf("%X", id)
+}
+
+// subresourceOperations name the operations on a subresource, as in S3
+// access logs.
+var subresourceOperations = []struct{ query, resource string }{
+	{"acl", "ACL"},
+	{"cors", "CORS"},
+	{"policy", "BUCKETPOLICY"},
+	{"lifecycle", "LIFECYCLE"},
+	{"versioning", "VERSIONING"},
+	{"notification", "NOTIFICATION"},
+	{"versions", "BUCKETVERSIONS"},
+	{"delete", "MULTI_OBJECT_DELETE"},
+	{"uploads", "UPLOADS"},
+	{"uploadId", "UPLOAD"},
+}
+
+// operationName names the S3 operation of a request the way S3 access logs
+// do, such as REST.GET.OBJECT or REST.PUT.VERSIONING.
+func operationName(r *http.Request) string {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	method := r.Method
+	if method == http.MethodOptions {
+		return "REST.OPTIONS.PREFLIGHT"
+	}
+	if r.Header.Get("x-amz-copy-source") != "" && method == http.MethodPut {
+		method = "COPY"
+	}
+
+	resource := "OBJECT"
+	switch {
+	case bucket == "":
+		resource = "SERVICE"
+	case key == "":
+		resource = "BUCKET"
+	}
+	query := r.URL.Query()
+	for _, sub := range subresourceOperations {
+		if query.Has(sub.query) {
+			resource = sub.resource
+			break
+		}
+	}
+	if resource == "UPLOAD" && (method == http.MethodPut || method == "COPY") {
+		resource = "PART"
+	}
+	return "REST." + method + "." + resource
+}
+
+// Healthz reports that the server is running.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok\n")
+}
+
+// Readyz reports whether the server can serve requests, that is whether its
+// metadata store and data directory are usable.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	err := storage.Check(h.server.Dir)
+	if err != nil {
+		log.Printf("Readiness check failed: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "not ready\n")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok\n")
+}
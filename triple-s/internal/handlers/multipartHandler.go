@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"triple-s/internal/notify"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+func (h *Handler) CreateMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	if !h.checkObjectKey(w, objectKey) || !h.authorize(w, r, "s3:PutObject") {
+		return
+	}
+	acl, ok := h.objectACL(w, r)
+	if !ok || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	headers, err := objectHeaders(r)
+	if err != nil {
+		h.sendMetadataTooLarge(w)
+		return
+	}
+
+	sse, ok := h.requestSSE(w, r)
+	if !ok {
+		return
+	}
+
+	upload, err := storage.CreateMultipartUpload(h.server.Dir, bucketName, objectKey, headers, sse, acl)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to create multipart upload")
+		return
+	}
+
+	setEncryptionHeaders(w, upload.Encryption)
+	h.sendXML(w, http.StatusOK, structure.InitiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      objectKey,
+		UploadID: upload.UploadID,
+	})
+}
+
+func (h *Handler) UploadPart(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-amz-copy-source") != "" {
+		h.UploadPartCopy(w, r)
+		return
+	}
+
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	query := r.URL.Query()
+
+	if !h.authorize(w, r, "s3:PutObject") {
+		return
+	}
+
+	partNumber, ok := h.partNumber(w, query.Get("partNumber"))
+	if !ok {
+		return
+	}
+
+	expectedMD5, err := contentMD5(r)
+	if err != nil {
+		h.sendInvalidDigest(w)
+		return
+	}
+
+	customerKey, ok := h.customerKey(w, r, sseCustomerPrefix)
+	if !ok {
+		return
+	}
+
+	if !h.checkBucket(w, bucketName) {
+		return
+	}
+	upload, ok := h.loadUpload(w, bucketName, objectKey, query.Get("uploadId"))
+	if !ok {
+		return
+	}
+
+	etag, err := storage.UploadPart(h.server.Dir, upload.UploadID, partNumber, r.Body, r.ContentLength, expectedMD5, customerKey)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to store part")
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(etag))
+	setEncryptionHeaders(w, upload.Encryption)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	if !h.authorize(w, r, "s3:PutObject") || !h.checkBucket(w, bucketName) {
+		return
+	}
+	upload, ok := h.loadUpload(w, bucketName, objectKey, r.URL.Query().Get("uploadId"))
+	if !ok {
+		return
+	}
+
+	var request structure.CompleteMultipartUpload
+	if !h.decodeXMLBody(w, r, &request) {
+		return
+	}
+
+	object, err := storage.CompleteMultipartUpload(h.server.Dir, upload, request.Parts)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidPart):
+			h.sendError(w, "InvalidPart", "One or more of the specified parts could not be found or the ETag did not match", http.StatusBadRequest)
+		case errors.Is(err, storage.ErrInvalidPartOrder):
+			h.sendError(w, "InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest)
+		case errors.Is(err, storage.ErrEntityTooSmall):
+			h.sendError(w, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size", http.StatusBadRequest)
+		default:
+			h.sendError(w, "InternalError", "Failed to complete multipart upload", http.StatusInternalServerError)
+		}
+		return
+	}
+	h.publish(r, notify.ObjectCreatedCompleteMultipartUpload, bucketName, object)
+
+	setVersionID(w, object.VersionID)
+	setEncryptionHeaders(w, object.Encryption)
+	h.sendXML(w, http.StatusOK, structure.CompleteMultipartUploadResult{
+		Location: fmt.Sprintf("/%s/%s", bucketName, objectKey),
+		Bucket:   bucketName,
+		Key:      objectKey,
+		ETag:     quoteETag(object.ETag),
+	})
+}
+
+func (h *Handler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	if !h.authorize(w, r, "s3:AbortMultipartUpload") || !h.checkBucket(w, bucketName) {
+		return
+	}
+	upload, ok := h.loadUpload(w, bucketName, objectKey, r.URL.Query().Get("uploadId"))
+	if !ok {
+		return
+	}
+
+	err := storage.AbortMultipartUpload(h.server.Dir, upload.UploadID)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to abort multipart upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListParts(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	query := r.URL.Query()
+
+	if !h.authorize(w, r, "s3:ListMultipartUploadParts") {
+		return
+	}
+
+	maxParts := 1000
+	if value := query.Get("max-parts"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			h.sendError(w, "InvalidArgument", "max-parts must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		maxParts = min(n, 1000)
+	}
+	marker := 0
+	if value := query.Get("part-number-marker"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			h.sendError(w, "InvalidArgument", "part-number-marker must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		marker = n
+	}
+
+	if !h.checkBucket(w, bucketName) {
+		return
+	}
+	upload, ok := h.loadUpload(w, bucketName, objectKey, query.Get("uploadId"))
+	if !ok {
+		return
+	}
+
+	parts, err := storage.ListParts(h.server.Dir, upload.UploadID)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to list parts", http.StatusInternalServerError)
+		return
+	}
+
+	response := structure.ListPartsResult{
+		Bucket:           bucketName,
+		Key:              objectKey,
+		UploadID:         upload.UploadID,
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+		Parts:            []structure.Part{},
+	}
+	for _, part := range parts {
+		if part.PartNumber <= marker {
+			continue
+		}
+		if len(response.Parts) == maxParts {
+			response.IsTruncated = true
+			break
+		}
+		response.Parts = append(response.Parts, structure.Part{
+			PartNumber:   part.PartNumber,
+			LastModified: part.LastModified,
+			ETag:         quoteETag(part.ETag),
+			Size:         part.Size,
+		})
+		response.NextPartNumberMarker = part.PartNumber
+	}
+
+	h.sendXML(w, http.StatusOK, response)
+}
+
+// partNumber parses the partNumber query parameter, sending the error
+// response itself when it is out of range.
+func (h *Handler) partNumber(w http.ResponseWriter, value string) (int, bool) {
+	partNumber, err := strconv.Atoi(value)
+	if err != nil || partNumber < storage.MinPartNumber || partNumber > storage.MaxPartNumber {
+		h.sendError(w, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive", http.StatusBadRequest)
+		return 0, false
+	}
+	return partNumber, true
+}
+
+func (h *Handler) loadUpload(w http.ResponseWriter, bucketName, objectKey, uploadID string) (*storage.MultipartUpload, bool) {
+	upload, err := storage.GetMultipartUpload(h.server.Dir, bucketName, objectKey, uploadID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoSuchUpload) {
+			h.sendError(w, "NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound)
+			return nil, false
+		}
+		h.sendError(w, "InternalError", "Failed to load multipart upload", http.StatusInternalServerError)
+		return nil, false
+	}
+	return upload, true
+}
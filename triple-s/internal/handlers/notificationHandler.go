@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"triple-s/internal/notify"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const (
+	maxNotificationRules  = 100
+	maxNotificationRuleID = 255
+)
+
+func (h *Handler) PutBucketNotification(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:PutBucketNotification") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	var config structure.NotificationConfiguration
+	if !h.decodeXMLBody(w, r, &config) {
+		return
+	}
+	if len(config.Webhooks) > maxNotificationRules {
+		h.sendMalformedXML(w)
+		return
+	}
+
+	rules := make([]storage.NotificationRule, 0, len(config.Webhooks))
+	ids := map[string]bool{}
+	for _, webhook := range config.Webhooks {
+		if len(webhook.ID) > maxNotificationRuleID {
+			h.sendError(w, "InvalidArgument", "ID length should not exceed allowed limit of 255", http.StatusBadRequest)
+			return
+		}
+		if webhook.ID != "" && ids[webhook.ID] {
+			h.sendError(w, "InvalidArgument", "Configuration Id must be unique. Found same Id for more than one configuration", http.StatusBadRequest)
+			return
+		}
+		ids[webhook.ID] = true
+
+		endpoint, err := url.Parse(webhook.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			h.sendError(w, "InvalidArgument", "The webhook endpoint must be an http or https URL: "+webhook.Endpoint, http.StatusBadRequest)
+			return
+		}
+		if len(webhook.Events) == 0 {
+			h.sendMalformedXML(w)
+			return
+		}
+		for _, event := range webhook.Events {
+			if !notify.ValidEvent(event) {
+				h.sendError(w, "InvalidArgument", "The event is not supported for notifications: "+event, http.StatusBadRequest)
+				return
+			}
+		}
+
+		rule := storage.NotificationRule{
+			ID:       webhook.ID,
+			Endpoint: webhook.Endpoint,
+			Events:   webhook.Events,
+		}
+		if webhook.Filter != nil {
+			seen := map[string]bool{}
+			for _, filter := range webhook.Filter.Rules {
+				name := strings.ToLower(filter.Name)
+				if name != "prefix" && name != "suffix" {
+					h.sendError(w, "InvalidArgument", "filter rule name must be either prefix or suffix", http.StatusBadRequest)
+					return
+				}
+				if seen[name] {
+					h.sendError(w, "InvalidArgument", "Cannot specify more than one "+name+" rule in a filter.", http.StatusBadRequest)
+					return
+				}
+				seen[name] = true
+				if name == "prefix" {
+					rule.Prefix = filter.Value
+				} else {
+					rule.Suffix = filter.Value
+				}
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	err := storage.PutBucketNotification(h.server.Dir, bucketName, rules)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to set bucket notification configuration")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketNotification(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:GetBucketNotification") {
+		return
+	}
+
+	rules, err := storage.GetBucketNotification(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to get bucket notification configuration")
+		return
+	}
+
+	config := structure.NotificationConfiguration{}
+	for _, rule := range rules {
+		webhook := structure.WebhookConfiguration{
+			ID:       rule.ID,
+			Endpoint: rule.Endpoint,
+			Events:   rule.Events,
+		}
+		if rule.Prefix != "" || rule.Suffix != "" {
+			webhook.Filter = &structure.NotificationFilter{}
+			if rule.Prefix != "" {
+				webhook.Filter.Rules = append(webhook.Filter.Rules, structure.FilterRule{Name: "prefix", Value: rule.Prefix})
+			}
+			if rule.Suffix != "" {
+				webhook.Filter.Rules = append(webhook.Filter.Rules, structure.FilterRule{Name: "suffix", Value: rule.Suffix})
+			}
+		}
+		config.Webhooks = append(config.Webhooks, webhook)
+	}
+
+	h.sendXML(w, http.StatusOK, config)
+}
+
+// publish queues the notifications of an event on object. Failing to queue
+// them does not fail the request, which has already taken effect.
+func (h *Handler) publish(r *http.Request, name, bucketName string, object structure.Object) {
+	event := notify.Event{
+		Name:      name,
+		Time:      time.Now(),
+		Bucket:    bucketName,
+		Key:       object.ObjectKey,
+		Size:      object.Size,
+		ETag:      object.ETag,
+		VersionID: object.VersionID,
+		Principal: accessKey(r),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		event.SourceIP = host
+	}
+
+	err := notify.Publish(h.server.Dir, event)
+	if err != nil {
+		log.Printf("Failed to queue %s notification for %s/%s: %v", name, bucketName, object.ObjectKey, err)
+	}
+}
@@ -1,20 +1,36 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"triple-s/internal/notify"
 	"triple-s/internal/storage"
 	"triple-s/internal/structure"
 )
 
 func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-amz-copy-source") != "" {
+		h.CopyObject(w, r)
+		return
+	}
+
 	bucketName := r.PathValue("bucketName")
 	objectKey := r.PathValue("objectKey")
 
+	if !h.checkObjectKey(w, objectKey) || !h.authorize(w, r, "s3:PutObject") {
+		return
+	}
+	acl, ok := h.objectACL(w, r)
+	if !ok {
+		return
+	}
+
 	exists, err := storage.BucketExists(h.server.Dir, bucketName)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
@@ -25,43 +41,50 @@ func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	contentType := r.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	headers, err := objectHeaders(r)
+	if err != nil {
+		h.sendMetadataTooLarge(w)
+		return
 	}
 
-	contentLenStr := r.Header.Get("Content-Length")
-	var contentLen int64
-	if contentLenStr != "" {
-		contentLen, err = strconv.ParseInt(contentLenStr, 10, 64)
-		if err != nil {
-			h.sendError(w, "InvalidContentLength", "Content length is not valid", http.StatusBadRequest)
-			return
-		}
+	sse, ok := h.requestSSE(w, r)
+	if !ok {
+		return
+	}
+
+	expectedMD5, err := contentMD5(r)
+	if err != nil {
+		h.sendInvalidDigest(w)
+		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	current, err := h.currentObject(bucketName, objectKey)
 	if err != nil {
-		h.sendError(w, "InternalError", "Failed to read request body", http.StatusInternalServerError)
+		h.sendError(w, "InternalError", "Failed to get object metadata", http.StatusInternalServerError)
 		return
 	}
-	if contentLen == 0 {
-		contentLen = int64(len(body))
+	if status := checkPreconditions(r, current); status != 0 {
+		h.sendPreconditionResult(w, status, current)
+		return
 	}
 
 	object := structure.Object{
-		ObjectKey:    objectKey,
-		Size:         contentLen,
-		ContentType:  contentType,
-		LastModified: time.Now(),
+		ObjectKey:     objectKey,
+		LastModified:  time.Now(),
+		ObjectHeaders: headers,
+		ACL:           acl,
 	}
 
-	err = storage.StoreObject(h.server.Dir, bucketName, objectKey, body, object)
+	object, err = storage.StoreObject(h.server.Dir, bucketName, objectKey, r.Body, r.ContentLength, expectedMD5, object, sse)
 	if err != nil {
-		h.sendError(w, "InternalError", "Failed to store object", http.StatusInternalServerError)
+		h.sendStoreError(w, err, "Failed to store object")
 		return
 	}
+	h.publish(r, notify.ObjectCreatedPut, bucketName, object)
 
+	w.Header().Set("ETag", quoteETag(object.ETag))
+	setVersionID(w, object.VersionID)
+	setEncryptionHeaders(w, object.Encryption)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -69,75 +92,242 @@ func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
 	bucketName := r.PathValue("bucketName")
 	objectKey := r.PathValue("objectKey")
 
-	exists, err := storage.BucketExists(h.server.Dir, bucketName)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+	if !h.authorize(w, r, "s3:GetObject") {
 		return
 	}
-	if !exists {
-		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+	customerKey, ok := h.customerKey(w, r, sseCustomerPrefix)
+	if !ok || !h.checkBucket(w, bucketName) {
 		return
 	}
 
-	objectExists, err := storage.ObjectExists(h.server.Dir, bucketName, objectKey)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check object existence", http.StatusInternalServerError)
+	// The headers, preconditions and range are all taken from the version
+	// that was opened, so that a concurrent write cannot mix two versions.
+	file, object, err := storage.OpenObject(h.server.Dir, bucketName, objectKey, r.URL.Query().Get("versionId"), customerKey)
+	if !h.checkReadable(w, r, object, err) {
 		return
 	}
-	if !objectExists {
-		h.sendError(w, "NoSuchKey", "The specified key does not exists", http.StatusNotFound)
+	defer file.Close()
+
+	br, ok := h.writeObjectHeaders(w, r, object)
+	if !ok {
 		return
 	}
 
-	object, err := storage.GetObjectMetadata(h.server.Dir, bucketName, objectKey)
+	_, err = io.Copy(w, io.NewSectionReader(file, br.start, br.length()))
 	if err != nil {
-		h.sendError(w, "InternalError", "Failed to get object metadata", http.StatusInternalServerError)
+		log.Printf("Failed to send object %s/%s: %v", bucketName, objectKey, err)
+	}
+}
+
+// HeadObject returns the same headers as GetObject without the body.
+func (h *Handler) HeadObject(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	if !h.authorize(w, r, "s3:GetObject") {
+		return
+	}
+	customerKey, ok := h.customerKey(w, r, sseCustomerPrefix)
+	if !ok || !h.checkBucket(w, bucketName) {
 		return
 	}
 
-	data, err := storage.GetObject(h.server.Dir, bucketName, objectKey)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to read object", http.StatusInternalServerError)
+	object, err := storage.GetObjectVersion(h.server.Dir, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if err == nil && !object.DeleteMarker {
+		err = storage.CheckCustomerKey(object.Encryption, customerKey)
+	}
+	if !h.checkReadable(w, r, object, err) {
 		return
 	}
+	h.writeObjectHeaders(w, r, object)
+}
 
-	w.Header().Set("Content-Type", object.ContentType)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", object.Size))
-	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+// checkReadable checks the outcome of looking up the object of a GET or
+// HEAD request and evaluates its preconditions. It sends the response
+// itself and returns false when the object must not be served.
+func (h *Handler) checkReadable(w http.ResponseWriter, r *http.Request, object *structure.Object, err error) bool {
+	if object != nil && object.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		setVersionID(w, object.VersionID)
+		h.sendError(w, "MethodNotAllowed", "The specified method is not allowed against this resource", http.StatusMethodNotAllowed)
+		return false
+	}
+	switch {
+	case errors.Is(err, storage.ErrNoSuchKey):
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return false
+	case errors.Is(err, storage.ErrNoSuchVersion):
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return false
+	case err != nil:
+		h.sendStoreError(w, err, "Failed to read object")
+		return false
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	if status := checkPreconditions(r, object); status != 0 {
+		h.sendPreconditionResult(w, status, object)
+		return false
+	}
+	return true
+}
+
+// writeObjectHeaders writes the status line and headers for serving object,
+// honoring a single byte range. It returns the range of the body to send,
+// or false when an InvalidRange error was sent instead.
+func (h *Handler) writeObjectHeaders(w http.ResponseWriter, r *http.Request, object *structure.Object) (byteRange, bool) {
+	full := byteRange{start: 0, end: object.Size - 1}
+
+	var br *byteRange
+	var err error
+	if ifRangeMatches(r.Header.Get("If-Range"), object) {
+		br, err = parseRange(r.Header.Get("Range"), object.Size)
+	}
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", object.Size))
+		h.sendError(w, "InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return full, false
+	}
+
+	setObjectHeaders(w, object)
+	setEncryptionHeaders(w, object.Encryption)
+	w.Header().Set("Accept-Ranges", "bytes")
+	setValidators(w, object)
+
+	if br == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return full, true
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, object.Size))
+	w.Header().Set("Content-Length", strconv.FormatInt(br.length(), 10))
+	w.WriteHeader(http.StatusPartialContent)
+	return *br, true
+}
+
+// currentObject returns the metadata of an existing object, or nil if there
+// is no object under the key.
+func (h *Handler) currentObject(bucketName, objectKey string) (*structure.Object, error) {
+	object, err := storage.GetObjectMetadata(h.server.Dir, bucketName, objectKey)
+	if errors.Is(err, storage.ErrNoSuchKey) {
+		return nil, nil
+	}
+	return object, err
 }
 
 func (h *Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	bucketName := r.PathValue("bucketName")
 	objectKey := r.PathValue("objectKey")
 
-	exists, err := storage.BucketExists(h.server.Dir, bucketName)
+	if !h.authorize(w, r, "s3:DeleteObject") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	deleted, err := storage.DeleteObject(h.server.Dir, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if errors.Is(err, storage.ErrNoSuchKey) {
+		h.sendError(w, "NoSuchKey", "The specified object does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+		h.sendError(w, "InternalError", "Failed to delete object", http.StatusInternalServerError)
 		return
 	}
-	if !exists {
-		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+
+	h.publish(r, removalEvent(deleted), bucketName, structure.Object{ObjectKey: objectKey, VersionID: deleted.VersionID})
+
+	if deleted.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
+	setVersionID(w, deleted.VersionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxDeleteObjects is the most keys a DeleteObjects request may name.
+const maxDeleteObjects = 1000
+
+// DeleteObjects deletes up to 1000 keys in one request. Each key is deleted
+// as by DeleteObject, and the outcome of each is reported in the response,
+// except for successful deletions in quiet mode.
+func (h *Handler) DeleteObjects(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.checkBucket(w, bucketName) {
 		return
 	}
 
-	objectExists, err := storage.ObjectExists(h.server.Dir, bucketName, objectKey)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check object existence", http.StatusInternalServerError)
+	var request structure.Delete
+	if !h.decodeXMLBody(w, r, &request) {
 		return
 	}
-	if !objectExists {
-		h.sendError(w, "NoSuchKey", "The specified object does not exist", http.StatusNotFound)
+	if len(request.Objects) == 0 || len(request.Objects) > maxDeleteObjects {
+		h.sendMalformedXML(w)
 		return
 	}
 
-	err = storage.DeleteObject(h.server.Dir, bucketName, objectKey)
+	// Each key is authorized on its own, as if it were deleted by
+	// DeleteObject. Keys that may not be deleted are reported as errors.
+	response := structure.DeleteResult{}
+	objects := make([]structure.ObjectIdentifier, 0, len(request.Objects))
+	for _, object := range request.Objects {
+		allowed, err := h.allowed(r, "s3:DeleteObject", bucketName, object.Key, object.VersionID)
+		if err != nil {
+			h.sendError(w, "InternalError", "Failed to check access", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			response.Errors = append(response.Errors, structure.DeleteError{
+				Key:       object.Key,
+				VersionID: object.VersionID,
+				Code:      "AccessDenied",
+				Message:   "Access Denied",
+			})
+			continue
+		}
+		objects = append(objects, object)
+	}
+
+	results, err := storage.DeleteObjects(h.server.Dir, bucketName, objects)
 	if err != nil {
-		h.sendError(w, "InternalError", "Failed to delete object", http.StatusInternalServerError)
+		h.sendStoreError(w, err, "Failed to delete objects")
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	for _, result := range results {
+		if result.Err != nil {
+			response.Errors = append(response.Errors, structure.DeleteError{
+				Key:       result.Key,
+				VersionID: result.VersionID,
+				Code:      "NoSuchVersion",
+				Message:   "The specified version does not exist",
+			})
+			continue
+		}
+		if result.Existed {
+			h.publish(r, removalEvent(result.Deleted), bucketName, structure.Object{ObjectKey: result.Key, VersionID: result.Deleted.VersionID})
+		}
+		if request.Quiet {
+			continue
+		}
+
+		deleted := structure.DeletedObject{Key: result.Key, VersionID: result.VersionID}
+		if result.Deleted.DeleteMarker {
+			deleted.DeleteMarker = true
+			deleted.DeleteMarkerVersionID = result.Deleted.VersionID
+		}
+		response.Deleted = append(response.Deleted, deleted)
+	}
+
+	h.sendXML(w, http.StatusOK, response)
+}
+
+// removalEvent names the notification event of a deletion.
+func removalEvent(deleted structure.Object) string {
+	if deleted.DeleteMarker {
+		return notify.ObjectRemovedDeleteMarkerCreated
+	}
+	return notify.ObjectRemovedDelete
 }
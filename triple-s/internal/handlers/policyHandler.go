@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"triple-s/internal/policy"
+	"triple-s/internal/storage"
+)
+
+func (h *Handler) PutBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:PutBucketPolicy") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	document, err := io.ReadAll(http.MaxBytesReader(w, r.Body, policy.MaxSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.sendError(w, "MalformedPolicy", "Policies must not exceed 20 KB", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to read request body")
+		return
+	}
+
+	_, err = policy.Parse(document, bucketName)
+	if err != nil {
+		h.sendError(w, "MalformedPolicy", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = storage.PutBucketPolicy(h.server.Dir, bucketName, string(document))
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to set bucket policy")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:GetBucketPolicy") {
+		return
+	}
+
+	document, err := storage.GetBucketPolicy(h.server.Dir, bucketName)
+	if errors.Is(err, storage.ErrNoSuchBucketPolicy) {
+		h.sendError(w, "NoSuchBucketPolicy", "The bucket policy does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to get bucket policy")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, document)
+}
+
+func (h *Handler) DeleteBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:DeleteBucketPolicy") {
+		return
+	}
+
+	err := storage.DeleteBucketPolicy(h.server.Dir, bucketName)
+	if err != nil {
+		h.sendStoreError(w, err, "Failed to delete bucket policy")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/internal/structure"
+)
+
+var errInvalidRange = errors.New("range not satisfiable")
+
+// byteRange is a satisfiable range of an object, end inclusive.
+type byteRange struct {
+	start, end int64
+}
+
+func (br byteRange) length() int64 {
+	return br.end - br.start + 1
+}
+
+// parseRange interprets a Range header for an object of the given size. Like
+// S3 it returns nil, meaning the whole object, for headers it does not
+// understand and for requests with several ranges. A single range that lies
+// entirely past the end of the object returns errInvalidRange.
+func parseRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errInvalidRange
+		}
+		n = min(n, size)
+		return &byteRange{start: size - n, end: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return nil, errInvalidRange
+	}
+
+	return &byteRange{start: start, end: end}, nil
+}
+
+// parseCopyRange interprets an x-amz-copy-source-range header. Unlike Range,
+// it must be a single bytes=first-last range lying within the source.
+func parseCopyRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return nil, errInvalidRange
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, errInvalidRange
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, errInvalidRange
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start || end >= size {
+		return nil, errInvalidRange
+	}
+
+	return &byteRange{start: start, end: end}, nil
+}
+
+// ifRangeMatches reports whether a Range header should be honored given the
+// If-Range validator, which may be an ETag or an HTTP date.
+func ifRangeMatches(header string, object *structure.Object) bool {
+	if header == "" {
+		return true
+	}
+	if strings.HasPrefix(header, `"`) {
+		return object.ETag != "" && strings.Trim(header, `"`) == object.ETag
+	}
+	t, ok := parseHTTPTime(header)
+	return ok && object.LastModified.Truncate(time.Second).Equal(t)
+}
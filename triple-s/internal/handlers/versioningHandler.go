@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+func (h *Handler) PutBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:PutBucketVersioning") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	config := structure.VersioningConfiguration{}
+	if !h.decodeXMLBody(w, r, &config) {
+		return
+	}
+	if config.Status != storage.VersioningEnabled && config.Status != storage.VersioningSuspended {
+		h.sendMalformedXML(w)
+		return
+	}
+
+	err := storage.SetBucketVersioning(h.server.Dir, bucketName, config.Status)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to set bucket versioning", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	if !h.authorize(w, r, "s3:GetBucketVersioning") {
+		return
+	}
+
+	status, err := storage.GetBucketVersioning(h.server.Dir, bucketName)
+	if errors.Is(err, storage.ErrNoSuchBucket) {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to get bucket versioning", http.StatusInternalServerError)
+		return
+	}
+
+	h.sendXML(w, http.StatusOK, structure.VersioningConfiguration{Status: status})
+}
+
+// ListObjectVersions lists every version and delete marker in a bucket,
+// with the same prefix, delimiter and max-keys handling as ListObjects.
+// Pages continue from key-marker and version-id-marker.
+func (h *Handler) ListObjectVersions(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	query := r.URL.Query()
+
+	if !h.authorize(w, r, "s3:ListBucketVersions") || !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	maxKeys := defaultMaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		var err error
+		maxKeys, err = strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			h.sendError(w, "InvalidArgument", "max-keys must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		maxKeys = min(maxKeys, defaultMaxKeys)
+	}
+
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	keyMarker := query.Get("key-marker")
+	versionIDMarker := query.Get("version-id-marker")
+	if versionIDMarker != "" && keyMarker == "" {
+		h.sendError(w, "InvalidArgument", "A version-id marker cannot be specified without a key marker", http.StatusBadRequest)
+		return
+	}
+
+	response := structure.ListVersionsResult{
+		Name:            bucketName,
+		Prefix:          prefix,
+		Delimiter:       delimiter,
+		KeyMarker:       keyMarker,
+		VersionIDMarker: versionIDMarker,
+		MaxKeys:         maxKeys,
+		Versions:        []any{},
+		CommonPrefixes:  []structure.CommonPrefix{},
+	}
+
+	count := 0
+	lastKey, lastVersionID := "", ""
+	err := storage.ListObjectVersions(h.server.Dir, bucketName, prefix, keyMarker, versionIDMarker, func(object structure.Object, isLatest bool) bool {
+		key := object.ObjectKey
+
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		// A key marker may be a common prefix returned by the previous page.
+		if commonPrefix != "" && (commonPrefix == lastKey || commonPrefix == keyMarker) {
+			return true
+		}
+
+		if count == maxKeys {
+			response.IsTruncated = true
+			return false
+		}
+		count++
+
+		if commonPrefix != "" {
+			response.CommonPrefixes = append(response.CommonPrefixes, structure.CommonPrefix{Prefix: commonPrefix})
+			lastKey, lastVersionID = commonPrefix, ""
+			return true
+		}
+
+		if object.DeleteMarker {
+			response.Versions = append(response.Versions, structure.DeleteMarkerEntry{
+				Key:          key,
+				VersionID:    object.VersionID,
+				IsLatest:     isLatest,
+				LastModified: object.LastModified,
+			})
+		} else {
+			response.Versions = append(response.Versions, structure.ObjectVersion{
+				Key:          key,
+				VersionID:    object.VersionID,
+				IsLatest:     isLatest,
+				LastModified: object.LastModified,
+				ETag:         quoteETagIfSet(object.ETag),
+				Size:         object.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+		lastKey, lastVersionID = key, object.VersionID
+		return true
+	})
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "InvalidArgument", "Invalid version id specified", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to list object versions", http.StatusInternalServerError)
+		return
+	}
+
+	if response.IsTruncated {
+		response.NextKeyMarker = lastKey
+		response.NextVersionIDMarker = lastVersionID
+	}
+
+	h.sendXML(w, http.StatusOK, response)
+}
+
+// setVersionID reports the version an operation read, wrote or removed.
+func setVersionID(w http.ResponseWriter, versionID string) {
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", versionID)
+	}
+}
@@ -0,0 +1,421 @@
+// Package metadb is a small embedded key-value store for metadata.
+//
+// All keys are held in an ordered in-memory index. Every committed
+// transaction is appended to a write-ahead log as one checksummed record and
+// fsynced before Update returns, so a crash loses at most the transaction
+// that was being written. The log is periodically folded into a snapshot
+// that is replaced atomically.
+package metadb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "snapshot"
+	walFile      = "wal"
+
+	// checkpointSize is the log size above which it is folded into the
+	// snapshot.
+	checkpointSize = 16 << 20
+	// snapshotBatch is the number of keys written per snapshot record.
+	snapshotBatch = 1024
+	// maxRecordSize guards against allocating huge buffers for a corrupt
+	// record header.
+	maxRecordSize = 1 << 30
+
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+var (
+	ErrClosed   = errors.New("metadb: database is closed")
+	ErrReadOnly = errors.New("metadb: write in a read-only transaction")
+	ErrCorrupt  = errors.New("metadb: corrupt record")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// DB is an open store. It is safe for concurrent use: View transactions run
+// in parallel, Update transactions are serialized.
+type DB struct {
+	mu      sync.RWMutex
+	dir     string
+	index   *skiplist
+	wal     *os.File
+	walSize int64
+	closed  bool
+}
+
+// Open loads the snapshot and replays the log found in dir, creating the
+// directory if needed. A record torn by a crash at the end of the log is
+// discarded, while a damaged record anywhere else makes Open fail with
+// ErrCorrupt.
+func Open(dir string) (*DB, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{dir: dir, index: newSkiplist()}
+
+	err = db.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	db.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := replay(db.wal, db.index)
+	if err != nil {
+		db.wal.Close()
+		return nil, fmt.Errorf("%s: %w", db.wal.Name(), err)
+	}
+	info, err := db.wal.Stat()
+	if err != nil {
+		db.wal.Close()
+		return nil, err
+	}
+	if valid < info.Size() {
+		log.Printf("metadb: discarding %d bytes of incomplete log", info.Size()-valid)
+		err = db.wal.Truncate(valid)
+		if err != nil {
+			db.wal.Close()
+			return nil, err
+		}
+	}
+	db.walSize = valid
+
+	if db.walSize > 0 {
+		err = db.checkpoint()
+		if err != nil {
+			db.wal.Close()
+			return nil, err
+		}
+	}
+
+	_, err = db.wal.Seek(db.walSize, io.SeekStart)
+	if err != nil {
+		db.wal.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Close checkpoints the log and releases the files.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+
+	err := db.checkpoint()
+	closeErr := db.wal.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// View runs fn in a read-only transaction.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return fn(&Tx{db: db})
+}
+
+// Update runs fn in a read-write transaction. Changes are visible to fn as
+// they are made and become durable when Update returns nil. If fn returns an
+// error, or the log cannot be written, every change is rolled back.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	tx := &Tx{db: db, writable: true}
+	err := fn(tx)
+	if err == nil && len(tx.ops) > 0 {
+		err = db.commit(tx.ops)
+	}
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+
+	if db.walSize > checkpointSize {
+		err = db.checkpoint()
+		if err != nil {
+			log.Printf("metadb: checkpoint failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) commit(ops []op) error {
+	record := encodeRecord(ops)
+
+	_, err := db.wal.Write(record)
+	if err == nil {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		// Drop whatever part of the record made it to the file so the log
+		// stays replayable.
+		db.wal.Truncate(db.walSize)
+		db.wal.Seek(db.walSize, io.SeekStart)
+		return err
+	}
+
+	db.walSize += int64(len(record))
+	return nil
+}
+
+// checkpoint writes the whole index to a new snapshot and empties the log.
+func (db *DB) checkpoint() error {
+	tmp, err := os.CreateTemp(db.dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	batch := make([]op, 0, snapshotBatch)
+	for n := db.index.head.next[0]; n != nil; n = n.next[0] {
+		batch = append(batch, op{kind: opPut, key: n.key, value: n.value})
+		if len(batch) == snapshotBatch {
+			w.Write(encodeRecord(batch))
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		w.Write(encodeRecord(batch))
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(db.dir, snapshotFile))
+	if err != nil {
+		return err
+	}
+	err = syncDir(db.dir)
+	if err != nil {
+		return err
+	}
+
+	// Replaying the log over the new snapshot would be harmless, so a crash
+	// before the truncation is safe.
+	err = db.wal.Truncate(0)
+	if err == nil {
+		_, err = db.wal.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	db.walSize = 0
+	return nil
+}
+
+func (db *DB) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(db.dir, snapshotFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	valid, err := replay(file, db.index)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name(), err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	// The snapshot is replaced atomically, so unlike the log it can never
+	// legitimately end in a partial record.
+	if valid != info.Size() {
+		return fmt.Errorf("%w in %s at offset %d", ErrCorrupt, file.Name(), valid)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+type op struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+// encodeRecord frames ops as one record: payload length and CRC-32C of the
+// payload, followed by the payload itself.
+func encodeRecord(ops []op) []byte {
+	payload := []byte{}
+	for _, o := range ops {
+		payload = append(payload, o.kind)
+		payload = binary.AppendUvarint(payload, uint64(len(o.key)))
+		payload = append(payload, o.key...)
+		if o.kind == opPut {
+			payload = binary.AppendUvarint(payload, uint64(len(o.value)))
+			payload = append(payload, o.value...)
+		}
+	}
+
+	record := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	return append(record, payload...)
+}
+
+// replay applies the records of r to index and returns the offset after the
+// last complete, intact record. A bad record is only expected at the very
+// end, torn by a crash while it was being written; one followed by more
+// data means the file is damaged, and replay fails with ErrCorrupt rather
+// than silently drop the records after it.
+func replay(r io.Reader, index *skiplist) (int64, error) {
+	br := bufio.NewReader(r)
+	var offset int64
+	header := make([]byte, 8)
+
+	for {
+		_, err := io.ReadFull(br, header)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return offset, checkTornRecord(br, offset, int64(size))
+		}
+		payload := make([]byte, size)
+		_, err = io.ReadFull(br, payload)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+
+		ops := []op{}
+		if crc32.Checksum(payload, crcTable) == binary.LittleEndian.Uint32(header[4:8]) {
+			ops, err = decodePayload(payload)
+		} else {
+			err = ErrCorrupt
+		}
+		if err != nil {
+			return offset, checkTornRecord(br, offset, 0)
+		}
+		for _, o := range ops {
+			if o.kind == opPut {
+				index.set(o.key, o.value)
+			} else {
+				index.delete(o.key)
+			}
+		}
+
+		offset += int64(8 + size)
+	}
+}
+
+// checkTornRecord makes sure that a bad record at offset is the last one:
+// br may hold no more than the unread part of its payload.
+func checkTornRecord(br *bufio.Reader, offset, unread int64) error {
+	left, err := io.Copy(io.Discard, br)
+	if err != nil {
+		return err
+	}
+	if left > unread {
+		return fmt.Errorf("%w at offset %d, followed by %d more bytes", ErrCorrupt, offset, left-unread)
+	}
+	return nil
+}
+
+func decodePayload(payload []byte) ([]op, error) {
+	ops := []op{}
+	for len(payload) > 0 {
+		kind := payload[0]
+		payload = payload[1:]
+		if kind != opPut && kind != opDelete {
+			return nil, ErrCorrupt
+		}
+
+		key, rest, err := readBytes(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = rest
+
+		o := op{kind: kind, key: string(key)}
+		if kind == opPut {
+			o.value, payload, err = readBytes(payload)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+func readBytes(buf []byte) ([]byte, []byte, error) {
+	n, size := binary.Uvarint(buf)
+	if size <= 0 || uint64(len(buf)-size) < n {
+		return nil, nil, ErrCorrupt
+	}
+	buf = buf[size:]
+	return buf[:n:n], buf[n:], nil
+}
@@ -0,0 +1,271 @@
+package metadb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeAndCrash commits one Put per key and abandons the store without
+// checkpointing, leaving the records in the log as a crash would.
+func writeAndCrash(t *testing.T, dir string, keys ...string) {
+	t.Helper()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, key := range keys {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(key, []byte("value of "+key))
+		})
+		if err != nil {
+			t.Fatalf("Update %s: %v", key, err)
+		}
+	}
+	db.wal.Close()
+}
+
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatalf("stat log: %v", err)
+	}
+	return info.Size()
+}
+
+func keys(t *testing.T, db *DB) []string {
+	t.Helper()
+
+	found := []string{}
+	err := db.View(func(tx *Tx) error {
+		tx.Ascend("", "", func(key string, value []byte) bool {
+			if string(value) != "value of "+key {
+				t.Errorf("value of %s = %q", key, value)
+			}
+			found = append(found, key)
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	return found
+}
+
+func TestReplayLog(t *testing.T) {
+	dir := t.TempDir()
+	writeAndCrash(t, dir, "b", "a", "c")
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	got := fmt.Sprint(keys(t, db))
+	if got != "[a b c]" {
+		t.Errorf("keys = %s, want [a b c]", got)
+	}
+	// Open folds the replayed log into the snapshot.
+	if size := walSize(t, dir); size != 0 {
+		t.Errorf("log size after Open = %d, want 0", size)
+	}
+}
+
+func TestReplayDeleteAndReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		for _, key := range []string{"a", "b", "c"} {
+			err := tx.Put(key, []byte("value of "+key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		return tx.Delete("b")
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	db.wal.Close()
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	got := fmt.Sprint(keys(t, db))
+	if got != "[a c]" {
+		t.Errorf("keys = %s, want [a c]", got)
+	}
+}
+
+func TestTornTailIsDiscarded(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, path string, size int64)
+		want   string
+	}{
+		{"cut payload", func(t *testing.T, path string, size int64) {
+			err := os.Truncate(path, size-3)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, "[a]"},
+		{"cut header", func(t *testing.T, path string, size int64) {
+			appendBytes(t, path, []byte{1, 2, 3})
+		}, "[a b]"},
+		{"bad checksum", func(t *testing.T, path string, size int64) {
+			flipByte(t, path, size-1)
+		}, "[a]"},
+		{"oversized header", func(t *testing.T, path string, size int64) {
+			appendBytes(t, path, []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+		}, "[a b]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeAndCrash(t, dir, "a", "b")
+			path := filepath.Join(dir, walFile)
+			tt.damage(t, path, walSize(t, dir))
+
+			db, err := Open(dir)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer db.Close()
+
+			got := fmt.Sprint(keys(t, db))
+			if got != tt.want {
+				t.Errorf("keys = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorruptionBeforeTailFailsOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeAndCrash(t, dir, "a", "b", "c")
+	// Damage the payload of the first record.
+	flipByte(t, filepath.Join(dir, walFile), 9)
+
+	_, err := Open(dir)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Open error = %v, want ErrCorrupt", err)
+	}
+	// The log must be left alone for inspection.
+	if size := walSize(t, dir); size == 0 {
+		t.Error("damaged log was truncated")
+	}
+}
+
+func TestCorruptSnapshotFailsOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeAndCrash(t, dir, "a")
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	path := filepath.Join(dir, snapshotFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(path, info.Size()-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(dir)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Open error = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	err = db.View(func(tx *Tx) error { return nil })
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("View error = %v, want ErrClosed", err)
+	}
+	err = db.Update(func(tx *Tx) error { return tx.Put("a", nil) })
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Update error = %v, want ErrClosed", err)
+	}
+}
+
+func appendBytes(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	b := make([]byte, 1)
+	_, err = file.ReadAt(b, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	_, err = file.WriteAt(b, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
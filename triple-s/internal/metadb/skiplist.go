@@ -0,0 +1,115 @@
+package metadb
+
+import "math/rand/v2"
+
+const (
+	maxLevel = 32
+	// levelP is the probability of a node reaching the next level.
+	levelP = 0.25
+)
+
+// skiplist is the ordered in-memory index of the store. Keys are compared
+// byte-wise, which matches the S3 listing order.
+type skiplist struct {
+	head  *node
+	level int
+	len   int
+}
+
+type node struct {
+	key   string
+	value []byte
+	next  []*node
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &node{next: make([]*node, maxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < maxLevel && rand.Float64() < levelP {
+		level++
+	}
+	return level
+}
+
+// findPath returns, for every level, the last node with a key lower than
+// key.
+func (s *skiplist) findPath(key string) [maxLevel]*node {
+	var path [maxLevel]*node
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		path[i] = x
+	}
+	return path
+}
+
+// seek returns the first node whose key is not lower than key.
+func (s *skiplist) seek(key string) *node {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+	}
+	return x.next[0]
+}
+
+func (s *skiplist) get(key string) ([]byte, bool) {
+	n := s.seek(key)
+	if n == nil || n.key != key {
+		return nil, false
+	}
+	return n.value, true
+}
+
+// set inserts or replaces key and returns the previous value, if any.
+func (s *skiplist) set(key string, value []byte) ([]byte, bool) {
+	path := s.findPath(key)
+	if n := path[0].next[0]; n != nil && n.key == key {
+		old := n.value
+		n.value = value
+		return old, true
+	}
+
+	level := randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			path[i] = s.head
+		}
+		s.level = level
+	}
+
+	n := &node{key: key, value: value, next: make([]*node, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = path[i].next[i]
+		path[i].next[i] = n
+	}
+	s.len++
+	return nil, false
+}
+
+// delete removes key and returns its value, if it was present.
+func (s *skiplist) delete(key string) ([]byte, bool) {
+	path := s.findPath(key)
+	n := path[0].next[0]
+	if n == nil || n.key != key {
+		return nil, false
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		path[i].next[i] = n.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.len--
+	return n.value, true
+}
@@ -0,0 +1,85 @@
+package metadb
+
+import "strings"
+
+// Tx is a transaction. It must not be used after the View or Update call
+// that created it returns.
+type Tx struct {
+	db       *DB
+	writable bool
+	ops      []op
+	undo     []undoEntry
+}
+
+type undoEntry struct {
+	key     string
+	value   []byte
+	existed bool
+}
+
+// Get returns the value stored under key. The returned slice must not be
+// modified.
+func (tx *Tx) Get(key string) ([]byte, bool) {
+	return tx.db.index.get(key)
+}
+
+// Put stores a copy of value under key.
+func (tx *Tx) Put(key string, value []byte) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+
+	value = append([]byte(nil), value...)
+	old, existed := tx.db.index.set(key, value)
+	tx.undo = append(tx.undo, undoEntry{key: key, value: old, existed: existed})
+	tx.ops = append(tx.ops, op{kind: opPut, key: key, value: value})
+	return nil
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (tx *Tx) Delete(key string) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+
+	old, existed := tx.db.index.delete(key)
+	if !existed {
+		return nil
+	}
+	tx.undo = append(tx.undo, undoEntry{key: key, value: old, existed: true})
+	tx.ops = append(tx.ops, op{kind: opDelete, key: key})
+	return nil
+}
+
+// Ascend calls fn for every key that starts with prefix and sorts after
+// after, in order, until fn returns false. The index must not be modified
+// from fn.
+func (tx *Tx) Ascend(prefix, after string, fn func(key string, value []byte) bool) {
+	start := prefix
+	if after > prefix {
+		// The smallest key greater than after.
+		start = after + "\x00"
+	}
+
+	for n := tx.db.index.seek(start); n != nil; n = n.next[0] {
+		if !strings.HasPrefix(n.key, prefix) {
+			return
+		}
+		if !fn(n.key, n.value) {
+			return
+		}
+	}
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		entry := tx.undo[i]
+		if entry.existed {
+			tx.db.index.set(entry.key, entry.value)
+		} else {
+			tx.db.index.delete(entry.key)
+		}
+	}
+	tx.undo = nil
+	tx.ops = nil
+}
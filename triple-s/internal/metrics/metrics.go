@@ -0,0 +1,152 @@
+// Package metrics counts requests and serves them, with the object and
+// bucket counts of the store, in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"triple-s/internal/storage"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	operation string
+	status    int
+}
+
+type operationStats struct {
+	// buckets counts the requests that took at most the matching bound of
+	// latencyBuckets.
+	buckets  []uint64
+	count    uint64
+	sum      float64
+	bytesIn  int64
+	bytesOut int64
+}
+
+var (
+	mu         sync.Mutex
+	requests   = map[requestKey]uint64{}
+	operations = map[string]*operationStats{}
+)
+
+// ObserveRequest records a request of the given S3 operation.
+func ObserveRequest(operation string, status int, duration time.Duration, bytesIn, bytesOut int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	requests[requestKey{operation, status}]++
+
+	stats, ok := operations[operation]
+	if !ok {
+		stats = &operationStats{buckets: make([]uint64, len(latencyBuckets))}
+		operations[operation] = stats
+	}
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			stats.buckets[i]++
+		}
+	}
+	stats.count++
+	stats.sum += seconds
+	stats.bytesIn += bytesIn
+	stats.bytesOut += bytesOut
+}
+
+// Handler serves the metrics of the store in dataDir.
+func Handler(dataDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := storage.Stats(dataDir)
+		if err != nil {
+			log.Printf("Failed to collect storage metrics: %v", err)
+			http.Error(w, "Failed to collect storage metrics", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeRequestMetrics(w)
+		writeStorageMetrics(w, stats)
+	})
+}
+
+func writeRequestMetrics(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	keys := make([]requestKey, 0, len(requests))
+	for key := range requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].status < keys[j].status
+	})
+	names := make([]string, 0, len(operations))
+	for name := range operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header(w, "triples_requests_total", "counter", "Requests handled, by S3 operation and HTTP status.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "triples_requests_total{operation=%s,status=\"%d\"} %d\n", quote(key.operation), key.status, requests[key])
+	}
+
+	header(w, "triples_request_duration_seconds", "histogram", "Time taken to handle requests, by S3 operation.")
+	for _, name := range names {
+		stats := operations[name]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "triples_request_duration_seconds_bucket{operation=%s,le=\"%s\"} %d\n", quote(name), strconv.FormatFloat(bound, 'g', -1, 64), stats.buckets[i])
+		}
+		fmt.Fprintf(w, "triples_request_duration_seconds_bucket{operation=%s,le=\"+Inf\"} %d\n", quote(name), stats.count)
+		fmt.Fprintf(w, "triples_request_duration_seconds_sum{operation=%s} %g\n", quote(name), stats.sum)
+		fmt.Fprintf(w, "triples_request_duration_seconds_count{operation=%s} %d\n", quote(name), stats.count)
+	}
+
+	header(w, "triples_received_bytes_total", "counter", "Request body bytes received, by S3 operation.")
+	for _, name := range names {
+		fmt.Fprintf(w, "triples_received_bytes_total{operation=%s} %d\n", quote(name), operations[name].bytesIn)
+	}
+	header(w, "triples_sent_bytes_total", "counter", "Response body bytes sent, by S3 operation.")
+	for _, name := range names {
+		fmt.Fprintf(w, "triples_sent_bytes_total{operation=%s} %d\n", quote(name), operations[name].bytesOut)
+	}
+}
+
+func writeStorageMetrics(w io.Writer, stats []storage.BucketStats) {
+	header(w, "triples_buckets", "gauge", "Buckets in the store.")
+	fmt.Fprintf(w, "triples_buckets %d\n", len(stats))
+
+	header(w, "triples_objects", "gauge", "Current objects, by bucket. Older versions and delete markers are not counted.")
+	for _, bucket := range stats {
+		fmt.Fprintf(w, "triples_objects{bucket=%s} %d\n", quote(bucket.Name), bucket.Objects)
+	}
+	header(w, "triples_object_bytes", "gauge", "Size of the current objects, by bucket.")
+	for _, bucket := range stats {
+		fmt.Fprintf(w, "triples_object_bytes{bucket=%s} %d\n", quote(bucket.Name), bucket.Bytes)
+	}
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// quote quotes a label value, escaping backslashes, quotes and newlines.
+func quote(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
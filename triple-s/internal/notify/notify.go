@@ -0,0 +1,190 @@
+// Package notify delivers S3 event notifications of buckets to webhooks.
+// Events are queued in the metadata store before delivery, so that they
+// survive restarts, and failed deliveries are retried with exponential
+// backoff.
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"triple-s/internal/storage"
+)
+
+// Event names, without the s3: prefix used in rules.
+const (
+	ObjectCreatedPut                     = "ObjectCreated:Put"
+	ObjectCreatedCopy                    = "ObjectCreated:Copy"
+	ObjectCreatedCompleteMultipartUpload = "ObjectCreated:CompleteMultipartUpload"
+	ObjectRemovedDelete                  = "ObjectRemoved:Delete"
+	ObjectRemovedDeleteMarkerCreated     = "ObjectRemoved:DeleteMarkerCreated"
+)
+
+const region = "us-east-1"
+
+// events are the event names rules may subscribe to.
+var events = []string{
+	"s3:ObjectCreated:*",
+	"s3:" + ObjectCreatedPut,
+	"s3:" + ObjectCreatedCopy,
+	"s3:" + ObjectCreatedCompleteMultipartUpload,
+	"s3:ObjectRemoved:*",
+	"s3:" + ObjectRemovedDelete,
+	"s3:" + ObjectRemovedDeleteMarkerCreated,
+}
+
+// Event is a change to an object.
+type Event struct {
+	Name      string
+	Time      time.Time
+	Bucket    string
+	Key       string
+	Size      int64
+	ETag      string
+	VersionID string
+	// Principal is the access key of the request, empty for anonymous
+	// requests.
+	Principal string
+	SourceIP  string
+}
+
+// ValidEvent reports whether rules may subscribe to the event name.
+func ValidEvent(name string) bool {
+	return slices.Contains(events, name)
+}
+
+// Publish queues event for delivery to every webhook of its bucket whose
+// rule matches it.
+func Publish(dataDir string, event Event) error {
+	rules, err := storage.GetBucketNotification(dataDir, event.Bucket)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	access, err := storage.GetBucketAccess(dataDir, event.Bucket)
+	if err != nil {
+		return err
+	}
+
+	notifications := []storage.Notification{}
+	for _, rule := range rules {
+		if !matches(rule, event) {
+			continue
+		}
+		payload, err := json.Marshal(message{Records: []record{newRecord(rule, event, access.Owner)}})
+		if err != nil {
+			return err
+		}
+		notifications = append(notifications, storage.Notification{
+			Endpoint:    rule.Endpoint,
+			Payload:     payload,
+			Queued:      event.Time,
+			NextAttempt: event.Time,
+		})
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	err = storage.QueueNotifications(dataDir, notifications)
+	if err != nil {
+		return err
+	}
+	wake()
+	return nil
+}
+
+func matches(rule storage.NotificationRule, event Event) bool {
+	if !strings.HasPrefix(event.Key, rule.Prefix) || !strings.HasSuffix(event.Key, rule.Suffix) {
+		return false
+	}
+	category, _, _ := strings.Cut(event.Name, ":")
+	for _, name := range rule.Events {
+		if name == "s3:"+event.Name || name == "s3:"+category+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// message is the JSON body of a notification, in the format of S3.
+type message struct {
+	Records []record `json:"Records"`
+}
+
+type record struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventSource       string            `json:"eventSource"`
+	AWSRegion         string            `json:"awsRegion"`
+	EventTime         string            `json:"eventTime"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      identity          `json:"userIdentity"`
+	RequestParameters requestParameters `json:"requestParameters"`
+	S3                s3Entity          `json:"s3"`
+}
+
+type identity struct {
+	PrincipalID string `json:"principalId"`
+}
+
+type requestParameters struct {
+	SourceIPAddress string `json:"sourceIPAddress"`
+}
+
+type s3Entity struct {
+	SchemaVersion   string       `json:"s3SchemaVersion"`
+	ConfigurationID string       `json:"configurationId"`
+	Bucket          bucketEntity `json:"bucket"`
+	Object          objectEntity `json:"object"`
+}
+
+type bucketEntity struct {
+	Name          string   `json:"name"`
+	OwnerIdentity identity `json:"ownerIdentity"`
+	ARN           string   `json:"arn"`
+}
+
+type objectEntity struct {
+	// Key is URL-encoded like a form value except for slashes, as in S3.
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	// Sequencer orders the events of a key.
+	Sequencer string `json:"sequencer"`
+}
+
+func newRecord(rule storage.NotificationRule, event Event, owner string) record {
+	principal := event.Principal
+	if principal == "" {
+		principal = "anonymous"
+	}
+	return record{
+		EventVersion:      "2.1",
+		EventSource:       "aws:s3",
+		AWSRegion:         region,
+		EventTime:         event.Time.UTC().Format("2006-01-02T15:04:05.000Z"),
+		EventName:         event.Name,
+		UserIdentity:      identity{PrincipalID: principal},
+		RequestParameters: requestParameters{SourceIPAddress: event.SourceIP},
+		S3: s3Entity{
+			SchemaVersion:   "1.0",
+			ConfigurationID: rule.ID,
+			Bucket: bucketEntity{
+				Name:          event.Bucket,
+				OwnerIdentity: identity{PrincipalID: owner},
+				ARN:           "arn:aws:s3:::" + event.Bucket,
+			},
+			Object: objectEntity{
+				Key:       strings.ReplaceAll(url.QueryEscape(event.Key), "%2F", "/"),
+				Size:      event.Size,
+				ETag:      event.ETag,
+				VersionID: event.VersionID,
+				Sequencer: fmt.Sprintf("%016X", event.Time.UnixNano()),
+			},
+		},
+	}
+}
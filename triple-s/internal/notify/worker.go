@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"triple-s/internal/storage"
+)
+
+const (
+	// pollInterval is how often the queue is checked for retries when no
+	// new events arrive.
+	pollInterval = 5 * time.Second
+	// batchSize is the most notifications delivered per pass.
+	batchSize = 100
+	// deliveryTimeout bounds each webhook call.
+	deliveryTimeout = 10 * time.Second
+
+	// Retries start after minBackoff and double up to maxBackoff. A
+	// notification still undelivered after maxAge is dropped.
+	minBackoff = time.Second
+	maxBackoff = time.Hour
+	maxAge     = 24 * time.Hour
+)
+
+var (
+	wakeup = make(chan struct{}, 1)
+	client = &http.Client{Timeout: deliveryTimeout}
+)
+
+// wake makes the worker deliver newly queued notifications right away.
+func wake() {
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// StartWorker delivers queued notifications in the background, including
+// those left over from a previous run.
+func StartWorker(dataDir string) {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			err := deliverDue(dataDir, time.Now())
+			if err != nil {
+				log.Printf("Failed to deliver notifications: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-wakeup:
+			}
+		}
+	}()
+}
+
+// deliverDue delivers the notifications due at now until none are left,
+// rescheduling those that fail. Each endpoint is delivered to concurrently,
+// so that a slow or unreachable one does not hold up the others.
+func deliverDue(dataDir string, now time.Time) error {
+	for {
+		due, err := storage.DueNotifications(dataDir, now, batchSize)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		byEndpoint := map[string][]storage.Notification{}
+		for _, notification := range due {
+			byEndpoint[notification.Endpoint] = append(byEndpoint[notification.Endpoint], notification)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(byEndpoint))
+		for _, notifications := range byEndpoint {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- deliverToEndpoint(dataDir, notifications)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// deliverToEndpoint delivers notifications for the same endpoint in order.
+// Once one fails the endpoint is likely down, so the rest are not tried
+// and wait for the retry of the failed one instead.
+func deliverToEndpoint(dataDir string, notifications []storage.Notification) error {
+	for i, notification := range notifications {
+		err := deliver(notification)
+		if err == nil {
+			err = storage.DeleteNotification(dataDir, notification.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		notification.Attempts++
+		backoff := min(minBackoff<<min(notification.Attempts-1, 30), maxBackoff)
+		notification.NextAttempt = time.Now().Add(backoff)
+		if notification.NextAttempt.Sub(notification.Queued) > maxAge {
+			log.Printf("Notification: giving up on %s after %d attempts: %v", notification.Endpoint, notification.Attempts, err)
+			err = storage.DeleteNotification(dataDir, notification.ID)
+		} else {
+			log.Printf("Notification: delivery to %s failed, retrying in %s: %v", notification.Endpoint, backoff, err)
+			err = storage.RescheduleNotification(dataDir, notification)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, rest := range notifications[i+1:] {
+			rest.NextAttempt = notification.NextAttempt
+			err = storage.RescheduleNotification(dataDir, rest)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// deliver POSTs a notification to its webhook. Any 2xx response counts as
+// delivered.
+func deliver(notification storage.Notification) error {
+	resp, err := client.Post(notification.Endpoint, "application/json", bytes.NewReader(notification.Payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package policy
+
+// Canned ACLs, set with the x-amz-acl header.
+const (
+	Private           = "private"
+	PublicRead        = "public-read"
+	PublicReadWrite   = "public-read-write"
+	AuthenticatedRead = "authenticated-read"
+)
+
+// Grantee groups and permissions of the grants of canned ACLs.
+const (
+	AllUsers           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	AuthenticatedUsers = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+
+	FullControl = "FULL_CONTROL"
+	Read        = "READ"
+	Write       = "WRITE"
+)
+
+// Grant gives a permission to a group of users.
+type Grant struct {
+	Group      string
+	Permission string
+}
+
+// cannedGrants are the grants of each canned ACL besides the full control
+// of the owner.
+var cannedGrants = map[string][]Grant{
+	Private:           nil,
+	PublicRead:        {{AllUsers, Read}},
+	PublicReadWrite:   {{AllUsers, Read}, {AllUsers, Write}},
+	AuthenticatedRead: {{AuthenticatedUsers, Read}},
+}
+
+// bucketPermissions and objectPermissions are the actions each permission
+// allows when granted on a bucket or on an object. Writing to a bucket
+// means writing and deleting its objects.
+var (
+	bucketPermissions = map[string][]string{
+		Read:  {"s3:ListBucket", "s3:ListBucketVersions", "s3:ListBucketMultipartUploads"},
+		Write: {"s3:PutObject", "s3:DeleteObject", "s3:DeleteObjectVersion", "s3:AbortMultipartUpload"},
+	}
+	objectPermissions = map[string][]string{
+		Read: {"s3:GetObject", "s3:GetObjectVersion"},
+	}
+)
+
+// ValidACL reports whether acl names a canned ACL. The empty ACL is
+// private.
+func ValidACL(acl string) bool {
+	_, ok := cannedGrants[acl]
+	return ok || acl == ""
+}
+
+// Grants returns the grants of a canned ACL besides the owner's.
+func Grants(acl string) []Grant {
+	return cannedGrants[acl]
+}
+
+// BucketACLAllows reports whether the canned ACL of a bucket lets a user
+// who is not its owner perform action.
+func BucketACLAllows(acl, action string, authenticated bool) bool {
+	return aclAllows(acl, bucketPermissions, action, authenticated)
+}
+
+// ObjectACLAllows reports whether the canned ACL of an object lets a user
+// who is not the owner of its bucket perform action.
+func ObjectACLAllows(acl, action string, authenticated bool) bool {
+	return aclAllows(acl, objectPermissions, action, authenticated)
+}
+
+func aclAllows(acl string, permissions map[string][]string, action string, authenticated bool) bool {
+	for _, grant := range cannedGrants[acl] {
+		if grant.Group == AuthenticatedUsers && !authenticated {
+			continue
+		}
+		for _, allowed := range permissions[grant.Permission] {
+			if allowed == action {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Condition compares a key of the request context with a list of values.
+// It holds if the key matches any of the values, or for negated operators
+// such as StringNotEquals, if it matches none of them.
+type Condition struct {
+	Operator string
+	Key      string
+	Values   []string
+}
+
+type operator struct {
+	test    func(actual, expected string) bool
+	negated bool
+}
+
+var operators = map[string]operator{
+	"StringEquals":              {stringEquals, false},
+	"StringNotEquals":           {stringEquals, true},
+	"StringEqualsIgnoreCase":    {strings.EqualFold, false},
+	"StringNotEqualsIgnoreCase": {strings.EqualFold, true},
+	"StringLike":                {stringLike, false},
+	"StringNotLike":             {stringLike, true},
+	"NumericEquals":             {numeric(func(c int) bool { return c == 0 }), false},
+	"NumericNotEquals":          {numeric(func(c int) bool { return c == 0 }), true},
+	"NumericLessThan":           {numeric(func(c int) bool { return c < 0 }), false},
+	"NumericLessThanEquals":     {numeric(func(c int) bool { return c <= 0 }), false},
+	"NumericGreaterThan":        {numeric(func(c int) bool { return c > 0 }), false},
+	"NumericGreaterThanEquals":  {numeric(func(c int) bool { return c >= 0 }), false},
+	"DateEquals":                {date(func(c int) bool { return c == 0 }), false},
+	"DateNotEquals":             {date(func(c int) bool { return c == 0 }), true},
+	"DateLessThan":              {date(func(c int) bool { return c < 0 }), false},
+	"DateLessThanEquals":        {date(func(c int) bool { return c <= 0 }), false},
+	"DateGreaterThan":           {date(func(c int) bool { return c > 0 }), false},
+	"DateGreaterThanEquals":     {date(func(c int) bool { return c >= 0 }), false},
+	"Bool":                      {strings.EqualFold, false},
+	"IpAddress":                 {ipAddress, false},
+	"NotIpAddress":              {ipAddress, true},
+}
+
+func parseCondition(operatorName, key string, raw json.RawMessage) (Condition, error) {
+	base := strings.TrimSuffix(operatorName, "IfExists")
+	if _, ok := operators[base]; !ok && base != "Null" {
+		return Condition{}, errors.New("Invalid Condition type: " + operatorName)
+	}
+
+	// Condition values may be written as JSON strings, numbers or booleans.
+	var values []any
+	if isList(raw) {
+		err := json.Unmarshal(raw, &values)
+		if err != nil {
+			return Condition{}, errors.New("Invalid Condition value for " + key)
+		}
+	} else {
+		var value any
+		err := json.Unmarshal(raw, &value)
+		if err != nil {
+			return Condition{}, errors.New("Invalid Condition value for " + key)
+		}
+		values = append(values, value)
+	}
+
+	condition := Condition{Operator: operatorName, Key: strings.ToLower(key)}
+	for _, value := range values {
+		switch value := value.(type) {
+		case string:
+			condition.Values = append(condition.Values, value)
+		case float64, bool:
+			condition.Values = append(condition.Values, fmt.Sprint(value))
+		default:
+			return Condition{}, errors.New("Invalid Condition value for " + key)
+		}
+	}
+	return condition, nil
+}
+
+func (c Condition) holds(context map[string]string) bool {
+	actual, present := context[c.Key]
+
+	base, ifExists := strings.CutSuffix(c.Operator, "IfExists")
+	if base == "Null" {
+		// Null tests whether the key is absent.
+		for _, value := range c.Values {
+			if strings.EqualFold(value, "true") != present {
+				return true
+			}
+		}
+		return false
+	}
+
+	op := operators[base]
+	if !present {
+		return ifExists || op.negated
+	}
+	for _, expected := range c.Values {
+		if op.test(actual, expected) {
+			return !op.negated
+		}
+	}
+	return op.negated
+}
+
+func stringEquals(actual, expected string) bool {
+	return actual == expected
+}
+
+func stringLike(actual, expected string) bool {
+	return match(expected, actual)
+}
+
+func numeric(accept func(int) bool) func(actual, expected string) bool {
+	return func(actual, expected string) bool {
+		a, err := strconv.ParseFloat(actual, 64)
+		if err != nil {
+			return false
+		}
+		e, err := strconv.ParseFloat(expected, 64)
+		if err != nil {
+			return false
+		}
+		switch {
+		case a < e:
+			return accept(-1)
+		case a > e:
+			return accept(1)
+		}
+		return accept(0)
+	}
+}
+
+func date(accept func(int) bool) func(actual, expected string) bool {
+	return func(actual, expected string) bool {
+		a, err := time.Parse(time.RFC3339, actual)
+		if err != nil {
+			return false
+		}
+		e, err := time.Parse(time.RFC3339, expected)
+		if err != nil {
+			return false
+		}
+		return accept(a.Compare(e))
+	}
+}
+
+// ipAddress matches an address against an address or CIDR block.
+func ipAddress(actual, expected string) bool {
+	addr, err := netip.ParseAddr(actual)
+	if err != nil {
+		return false
+	}
+	if !strings.Contains(expected, "/") {
+		e, err := netip.ParseAddr(expected)
+		return err == nil && e == addr.Unmap()
+	}
+	prefix, err := netip.ParsePrefix(expected)
+	return err == nil && prefix.Contains(addr.Unmap())
+}
@@ -0,0 +1,345 @@
+// Package policy evaluates S3 bucket policies and canned ACLs.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// MaxSize is the largest bucket policy S3 accepts, in bytes.
+const MaxSize = 20 << 10
+
+const arnPrefix = "arn:aws:s3:::"
+
+// Decision is the outcome of evaluating a policy.
+type Decision int
+
+const (
+	// Neutral means that no statement applies to the request.
+	Neutral Decision = iota
+	Allowed
+	Denied
+)
+
+// Policy is a parsed bucket policy document.
+type Policy struct {
+	Statements []Statement
+}
+
+// Statement allows or denies a set of actions on a set of resources to a
+// set of principals, when all its conditions hold.
+type Statement struct {
+	Sid   string
+	Allow bool
+	// Principals are access key IDs, or "*" for everyone including
+	// anonymous requests.
+	Principals []string
+	Actions    []string
+	Resources  []string
+	Conditions []Condition
+}
+
+// Request is an action to authorize.
+type Request struct {
+	// Principal is the access key ID the request was signed with, or empty
+	// for an anonymous request.
+	Principal string
+	Action    string
+	Resource  string
+	// Context holds the condition keys of the request, such as
+	// aws:SourceIp, under their lowercase names. Keys that do not apply to
+	// the request are absent.
+	Context map[string]string
+}
+
+// ARN returns the resource name of a bucket, or of an object when key is
+// not empty.
+func ARN(bucket, key string) string {
+	if key == "" {
+		return arnPrefix + bucket
+	}
+	return arnPrefix + bucket + "/" + key
+}
+
+// document is the JSON form of a policy. Most fields may hold either a
+// single value or a list.
+type document struct {
+	Version   string
+	Statement json.RawMessage
+}
+
+type statementDocument struct {
+	Sid       string
+	Effect    string
+	Principal json.RawMessage
+	Action    json.RawMessage
+	Resource  json.RawMessage
+	Condition map[string]map[string]json.RawMessage
+}
+
+// Parse parses and validates the policy of a bucket. The error message
+// says what is wrong with the document.
+func Parse(data []byte, bucket string) (*Policy, error) {
+	var doc document
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, errors.New("Policies must be valid JSON")
+	}
+	if doc.Version != "" && doc.Version != "2012-10-17" && doc.Version != "2008-10-17" {
+		return nil, errors.New("The policy must contain a valid version string")
+	}
+
+	var statements []statementDocument
+	if isList(doc.Statement) {
+		err = json.Unmarshal(doc.Statement, &statements)
+	} else {
+		var statement statementDocument
+		err = json.Unmarshal(doc.Statement, &statement)
+		statements = append(statements, statement)
+	}
+	if err != nil || len(statements) == 0 {
+		return nil, errors.New("Missing required field Statement")
+	}
+
+	policy := &Policy{}
+	for _, doc := range statements {
+		statement, err := parseStatement(doc, bucket)
+		if err != nil {
+			return nil, err
+		}
+		policy.Statements = append(policy.Statements, statement)
+	}
+	return policy, nil
+}
+
+func parseStatement(doc statementDocument, bucket string) (Statement, error) {
+	statement := Statement{Sid: doc.Sid}
+
+	switch doc.Effect {
+	case "Allow":
+		statement.Allow = true
+	case "Deny":
+	default:
+		return Statement{}, errors.New("Invalid effect: " + doc.Effect)
+	}
+
+	var err error
+	statement.Principals, err = parsePrincipal(doc.Principal)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	statement.Actions, err = stringList(doc.Action)
+	if err != nil || len(statement.Actions) == 0 {
+		return Statement{}, errors.New("Missing required field Action")
+	}
+	for _, action := range statement.Actions {
+		if !validAction(action) {
+			return Statement{}, errors.New("Policy has invalid action: " + action)
+		}
+	}
+
+	statement.Resources, err = stringList(doc.Resource)
+	if err != nil || len(statement.Resources) == 0 {
+		return Statement{}, errors.New("Missing required field Resource")
+	}
+	for _, resource := range statement.Resources {
+		name, ok := strings.CutPrefix(resource, arnPrefix)
+		resourceBucket, _, _ := strings.Cut(name, "/")
+		if !ok || !match(resourceBucket, bucket) {
+			return Statement{}, errors.New("Policy has invalid resource: " + resource)
+		}
+	}
+
+	for operator, keys := range doc.Condition {
+		for key, raw := range keys {
+			condition, err := parseCondition(operator, key, raw)
+			if err != nil {
+				return Statement{}, err
+			}
+			statement.Conditions = append(statement.Conditions, condition)
+		}
+	}
+
+	return statement, nil
+}
+
+// parsePrincipal accepts "*", {"AWS": "*"} and {"AWS": [access key IDs]}.
+func parsePrincipal(raw json.RawMessage) ([]string, error) {
+	var wildcard string
+	if json.Unmarshal(raw, &wildcard) == nil {
+		if wildcard != "*" {
+			return nil, errors.New("Invalid principal in policy")
+		}
+		return []string{"*"}, nil
+	}
+
+	var principal map[string]json.RawMessage
+	err := json.Unmarshal(raw, &principal)
+	if err != nil || len(principal) == 0 {
+		return nil, errors.New("Missing required field Principal")
+	}
+	aws, ok := principal["AWS"]
+	if !ok || len(principal) > 1 {
+		return nil, errors.New("Invalid principal in policy")
+	}
+	principals, err := stringList(aws)
+	if err != nil || len(principals) == 0 {
+		return nil, errors.New("Invalid principal in policy")
+	}
+	return principals, nil
+}
+
+func isList(raw json.RawMessage) bool {
+	return strings.HasPrefix(strings.TrimSpace(string(raw)), "[")
+}
+
+// stringList decodes a JSON string or list of strings.
+func stringList(raw json.RawMessage) ([]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if !isList(raw) {
+		var value string
+		err := json.Unmarshal(raw, &value)
+		return []string{value}, err
+	}
+	var values []string
+	err := json.Unmarshal(raw, &values)
+	return values, err
+}
+
+// Evaluate decides a request. A matching Deny statement overrides any
+// matching Allow statement.
+func (p *Policy) Evaluate(req Request) Decision {
+	decision := Neutral
+	for _, statement := range p.Statements {
+		if !statement.applies(req) {
+			continue
+		}
+		if !statement.Allow {
+			return Denied
+		}
+		decision = Allowed
+	}
+	return decision
+}
+
+func (s Statement) applies(req Request) bool {
+	return s.matchesPrincipal(req.Principal) &&
+		matchAny(s.Actions, req.Action, true) &&
+		matchAny(s.Resources, req.Resource, false) &&
+		s.conditionsHold(req.Context)
+}
+
+func (s Statement) matchesPrincipal(principal string) bool {
+	for _, p := range s.Principals {
+		if p == "*" || (principal != "" && p == principal) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Statement) conditionsHold(context map[string]string) bool {
+	for _, condition := range s.Conditions {
+		if !condition.holds(context) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(patterns []string, value string, ignoreCase bool) bool {
+	if ignoreCase {
+		value = strings.ToLower(value)
+	}
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern = strings.ToLower(pattern)
+		}
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether value matches pattern, in which * stands for any
+// sequence of characters and ? for any single character.
+func match(pattern, value string) bool {
+	// Backtrack to the last * when the rest of the pattern fails to match.
+	p, v := 0, 0
+	star, next := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, v
+			p++
+		case star >= 0:
+			p = star + 1
+			next++
+			v = next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func validAction(action string) bool {
+	name, ok := strings.CutPrefix(strings.ToLower(action), "s3:")
+	if !ok {
+		return action == "*"
+	}
+	if strings.ContainsAny(name, "*?") {
+		return true
+	}
+	for _, known := range actions {
+		if strings.EqualFold(known, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// actions are the actions the server authorizes.
+var actions = []string{
+	"s3:ListAllMyBuckets",
+	"s3:CreateBucket",
+	"s3:DeleteBucket",
+	"s3:ListBucket",
+	"s3:ListBucketVersions",
+	"s3:ListBucketMultipartUploads",
+	"s3:GetBucketVersioning",
+	"s3:PutBucketVersioning",
+	"s3:GetLifecycleConfiguration",
+	"s3:PutLifecycleConfiguration",
+	"s3:GetBucketCORS",
+	"s3:PutBucketCORS",
+	"s3:GetBucketNotification",
+	"s3:PutBucketNotification",
+	"s3:GetBucketPolicy",
+	"s3:PutBucketPolicy",
+	"s3:DeleteBucketPolicy",
+	"s3:GetBucketAcl",
+	"s3:PutBucketAcl",
+	"s3:GetObject",
+	"s3:GetObjectVersion",
+	"s3:PutObject",
+	"s3:DeleteObject",
+	"s3:DeleteObjectVersion",
+	"s3:GetObjectAcl",
+	"s3:GetObjectVersionAcl",
+	"s3:PutObjectAcl",
+	"s3:PutObjectVersionAcl",
+	"s3:ListMultipartUploadParts",
+	"s3:AbortMultipartUpload",
+}
@@ -0,0 +1,214 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+const examplePolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Sid": "PublicRead",
+			"Effect": "Allow",
+			"Principal": "*",
+			"Action": "s3:GetObject",
+			"Resource": "arn:aws:s3:::photos/public/*"
+		},
+		{
+			"Sid": "Uploader",
+			"Effect": "Allow",
+			"Principal": {"AWS": ["AK1"]},
+			"Action": ["s3:PutObject", "s3:Get*"],
+			"Resource": ["arn:aws:s3:::photos", "arn:aws:s3:::photos/*"]
+		},
+		{
+			"Sid": "OfficeOnly",
+			"Effect": "Deny",
+			"Principal": {"AWS": "*"},
+			"Action": "s3:*",
+			"Resource": "arn:aws:s3:::photos/private/*",
+			"Condition": {"NotIpAddress": {"aws:SourceIp": ["10.0.0.0/8", "192.168.1.1"]}}
+		}
+	]
+}`
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(examplePolicy), "photos")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	office := map[string]string{"aws:sourceip": "10.1.2.3"}
+	home := map[string]string{"aws:sourceip": "203.0.113.7"}
+	tests := []struct {
+		name string
+		req  Request
+		want Decision
+	}{
+		{"anonymous public read", Request{"", "s3:GetObject", ARN("photos", "public/a.jpg"), home}, Allowed},
+		{"anonymous write", Request{"", "s3:PutObject", ARN("photos", "public/a.jpg"), home}, Neutral},
+		{"anonymous outside the prefix", Request{"", "s3:GetObject", ARN("photos", "publicity.jpg"), home}, Neutral},
+		{"anonymous is not a named principal", Request{"", "s3:PutObject", ARN("photos", "a.jpg"), office}, Neutral},
+		{"named principal", Request{"AK1", "s3:PutObject", ARN("photos", "a.jpg"), home}, Allowed},
+		{"action wildcard ignores case", Request{"AK1", "S3:GETOBJECTVERSION", ARN("photos", "a.jpg"), home}, Allowed},
+		{"other principal", Request{"AK2", "s3:PutObject", ARN("photos", "a.jpg"), home}, Neutral},
+		{"deny overrides allow", Request{"AK1", "s3:GetObject", ARN("photos", "private/a.jpg"), home}, Denied},
+		{"condition does not hold", Request{"AK1", "s3:GetObject", ARN("photos", "private/a.jpg"), office}, Allowed},
+		{"single address", Request{"AK1", "s3:GetObject", ARN("photos", "private/a.jpg"), map[string]string{"aws:sourceip": "192.168.1.1"}}, Allowed},
+		{"negated condition on absent key", Request{"AK1", "s3:GetObject", ARN("photos", "private/a.jpg"), nil}, Denied},
+		{"bucket resource", Request{"AK1", "s3:GetBucketPolicy", ARN("photos", ""), home}, Allowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Evaluate(tt.req); got != tt.want {
+				t.Errorf("Evaluate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	statement := `{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}`
+	document := func(statement string) string {
+		return `{"Version": "2012-10-17", "Statement": ` + statement + `}`
+	}
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{"not JSON", `{`, "Policies must be valid JSON"},
+		{"bad version", `{"Version": "2020-01-01", "Statement": ` + statement + `}`, "valid version"},
+		{"no statement", `{"Version": "2012-10-17"}`, "Missing required field Statement"},
+		{"bad effect", document(strings.Replace(statement, "Allow", "Permit", 1)), "Invalid effect: Permit"},
+		{"no principal", document(strings.Replace(statement, `"Principal": "*", `, "", 1)), "Missing required field Principal"},
+		{"principal not a key list", document(strings.Replace(statement, `"*"`, `{"Service": "s3"}`, 1)), "Invalid principal"},
+		{"unknown action", document(strings.Replace(statement, "s3:GetObject", "s3:Launch", 1)), "invalid action: s3:Launch"},
+		{"other bucket", document(strings.Replace(statement, ":::photos", ":::videos", 1)), "invalid resource"},
+		{"no resource", document(strings.Replace(statement, `, "Resource": "arn:aws:s3:::photos/*"`, "", 1)), "Missing required field Resource"},
+		{"bad condition", document(strings.Replace(statement, `}`, `, "Condition": {"StringMaybe": {"aws:Referer": "x"}}}`, 1)), "Invalid Condition type"},
+		{"condition object value", document(strings.Replace(statement, `}`, `, "Condition": {"StringEquals": {"aws:Referer": {"a": 1}}}}`, 1)), "Invalid Condition value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.document), "photos")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		operator string
+		values   []string
+		context  map[string]string
+		want     bool
+	}{
+		{"StringEquals", []string{"a", "b"}, map[string]string{"k": "b"}, true},
+		{"StringEquals", []string{"a"}, map[string]string{"k": "A"}, false},
+		{"StringEquals", []string{"a"}, nil, false},
+		{"StringEqualsIfExists", []string{"a"}, nil, true},
+		{"StringNotEquals", []string{"a"}, map[string]string{"k": "b"}, true},
+		{"StringEqualsIgnoreCase", []string{"a"}, map[string]string{"k": "A"}, true},
+		{"StringLike", []string{"img-??.*"}, map[string]string{"k": "img-01.png"}, true},
+		{"StringNotLike", []string{"*.exe"}, map[string]string{"k": "setup.exe"}, false},
+		{"NumericLessThan", []string{"10"}, map[string]string{"k": "9.5"}, true},
+		{"NumericGreaterThanEquals", []string{"10"}, map[string]string{"k": "9"}, false},
+		{"NumericEquals", []string{"10"}, map[string]string{"k": "ten"}, false},
+		{"DateLessThan", []string{"2024-01-01T00:00:00Z"}, map[string]string{"k": "2023-12-31T23:59:59Z"}, true},
+		{"DateGreaterThan", []string{"2024-01-01T00:00:00Z"}, map[string]string{"k": "2023-12-31T23:59:59Z"}, false},
+		{"Bool", []string{"true"}, map[string]string{"k": "True"}, true},
+		{"IpAddress", []string{"2001:db8::/32"}, map[string]string{"k": "2001:db8::1"}, true},
+		{"IpAddress", []string{"10.0.0.0/8"}, map[string]string{"k": "::ffff:10.0.0.1"}, true},
+		{"NotIpAddress", []string{"10.0.0.0/8"}, map[string]string{"k": "11.0.0.1"}, true},
+		{"Null", []string{"true"}, nil, true},
+		{"Null", []string{"true"}, map[string]string{"k": "x"}, false},
+		{"Null", []string{"false"}, map[string]string{"k": "x"}, true},
+	}
+
+	for _, tt := range tests {
+		c := Condition{Operator: tt.operator, Key: "k", Values: tt.values}
+		if got := c.holds(tt.context); got != tt.want {
+			t.Errorf("%s %v on %v = %v, want %v", tt.operator, tt.values, tt.context, got, tt.want)
+		}
+	}
+}
+
+func TestConditionValues(t *testing.T) {
+	c, err := parseCondition("NumericLessThan", "S3:Max-Keys", []byte(`[10, "20", true]`))
+	if err != nil {
+		t.Fatalf("parseCondition: %v", err)
+	}
+	if c.Key != "s3:max-keys" || strings.Join(c.Values, ",") != "10,20,true" {
+		t.Errorf("condition = %+v", c)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbcd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*a*b", "xaxxaxb", true},
+		{"photos/*/raw", "photos/2024/01/raw", true},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestACLAllows(t *testing.T) {
+	tests := []struct {
+		acl           string
+		bucket        bool
+		action        string
+		authenticated bool
+		want          bool
+	}{
+		{Private, true, "s3:ListBucket", true, false},
+		{"", false, "s3:GetObject", true, false},
+		{PublicRead, true, "s3:ListBucket", false, true},
+		{PublicRead, true, "s3:PutObject", false, false},
+		{PublicReadWrite, true, "s3:DeleteObject", false, true},
+		{PublicReadWrite, true, "s3:PutBucketPolicy", true, false},
+		{AuthenticatedRead, true, "s3:ListBucket", false, false},
+		{AuthenticatedRead, true, "s3:ListBucket", true, true},
+		{PublicRead, false, "s3:GetObjectVersion", false, true},
+		{PublicReadWrite, false, "s3:PutObject", false, false},
+		{AuthenticatedRead, false, "s3:GetObject", false, false},
+		{AuthenticatedRead, false, "s3:GetObject", true, true},
+	}
+	for _, tt := range tests {
+		allows := ObjectACLAllows
+		if tt.bucket {
+			allows = BucketACLAllows
+		}
+		if got := allows(tt.acl, tt.action, tt.authenticated); got != tt.want {
+			t.Errorf("ACL %q (bucket %v) allows %s, authenticated %v = %v, want %v", tt.acl, tt.bucket, tt.action, tt.authenticated, got, tt.want)
+		}
+	}
+}
+
+func TestValidACL(t *testing.T) {
+	for _, acl := range []string{"", Private, PublicRead, PublicReadWrite, AuthenticatedRead} {
+		if !ValidACL(acl) {
+			t.Errorf("ValidACL(%q) = false", acl)
+		}
+	}
+	if ValidACL("bucket-owner-full-control") {
+		t.Error("ValidACL accepts an unsupported ACL")
+	}
+}
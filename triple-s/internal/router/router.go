@@ -1,22 +1,152 @@
 package router
 
 import (
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 
 	h "triple-s/internal/handlers"
+	"triple-s/internal/metrics"
 	s "triple-s/internal/structure"
 )
 
-func Router(server *s.Server) *http.ServeMux {
+// Router registers the S3 routes. Every request is logged and counted, is
+// authenticated first when the server has credentials, and cross-origin
+// requests get the CORS headers of their bucket. The health and metrics
+// endpoints are served apart from the S3 API.
+func Router(server *s.Server) http.Handler {
 	mux := http.NewServeMux()
 	handler := h.NewHandler(server)
 
-	mux.HandleFunc("PUT /{bucketName}", handler.PutBucket)
+	putBucket := bySubresource(handler.PutBucket,
+		subresource{"versioning", handler.PutBucketVersioning},
+		subresource{"lifecycle", handler.PutBucketLifecycle},
+		subresource{"policy", handler.PutBucketPolicy},
+		subresource{"cors", handler.PutBucketCors},
+		subresource{"notification", handler.PutBucketNotification},
+		subresource{"acl", handler.PutBucketAcl},
+	)
+	getBucket := bySubresource(handler.ListObjects,
+		subresource{"versioning", handler.GetBucketVersioning},
+		subresource{"versions", handler.ListObjectVersions},
+		subresource{"lifecycle", handler.GetBucketLifecycle},
+		subresource{"policy", handler.GetBucketPolicy},
+		subresource{"cors", handler.GetBucketCors},
+		subresource{"notification", handler.GetBucketNotification},
+		subresource{"acl", handler.GetBucketAcl},
+	)
+	postBucket := bySubresource(nil,
+		subresource{"delete", handler.DeleteObjects},
+	)
+	deleteBucket := bySubresource(handler.DeleteBucket,
+		subresource{"lifecycle", handler.DeleteBucketLifecycle},
+		subresource{"policy", handler.DeleteBucketPolicy},
+		subresource{"cors", handler.DeleteBucketCors},
+	)
+
 	mux.HandleFunc("GET /{$}", handler.GetBuckets)
-	mux.HandleFunc("DELETE /{bucketName}", handler.DeleteBucket)
-	mux.HandleFunc("PUT /{bucketName}/{objectKey}", handler.PutObject)
-	mux.HandleFunc("GET /{bucketName}/{objectKey}", handler.GetObject)
-	mux.HandleFunc("DELETE /{bucketName}/{objectKey}", handler.DeleteObject)
+	mux.HandleFunc("PUT /{bucketName}", putBucket)
+	mux.HandleFunc("GET /{bucketName}", getBucket)
+	mux.HandleFunc("POST /{bucketName}", postBucket)
+	mux.HandleFunc("HEAD /{bucketName}", handler.HeadBucket)
+	mux.HandleFunc("DELETE /{bucketName}", deleteBucket)
+	mux.HandleFunc("OPTIONS /{bucketName}", handler.PreflightCors)
+
+	mux.HandleFunc("PUT /{bucketName}/{objectKey...}", orBucket(putBucket, bySubresource(handler.PutObject,
+		subresource{"uploadId", handler.UploadPart},
+		subresource{"acl", handler.PutObjectAcl},
+	)))
+	mux.HandleFunc("GET /{bucketName}/{objectKey...}", orBucket(getBucket, bySubresource(handler.GetObject,
+		subresource{"uploadId", handler.ListParts},
+		subresource{"acl", handler.GetObjectAcl},
+	)))
+	mux.HandleFunc("HEAD /{bucketName}/{objectKey...}", orBucket(handler.HeadBucket, handler.HeadObject))
+	mux.HandleFunc("POST /{bucketName}/{objectKey...}", orBucket(postBucket, bySubresource(nil,
+		subresource{"uploads", handler.CreateMultipartUpload},
+		subresource{"uploadId", handler.CompleteMultipartUpload},
+	)))
+	mux.HandleFunc("DELETE /{bucketName}/{objectKey...}", orBucket(deleteBucket, bySubresource(handler.DeleteObject,
+		subresource{"uploadId", handler.AbortMultipartUpload},
+	)))
+	mux.HandleFunc("OPTIONS /{bucketName}/{objectKey...}", handler.PreflightCors)
+
+	var accessLog *log.Logger
+	if server.AccessLog != nil {
+		accessLog = log.New(server.AccessLog, "", 0)
+	}
+	api := handler.LogRequests(handler.CORS(handler.Authenticate(escapeObjectKeys(mux))), accessLog)
+
+	return monitoring(api, map[string]http.Handler{
+		"/healthz": http.HandlerFunc(handler.Healthz),
+		"/readyz":  http.HandlerFunc(handler.Readyz),
+		"/metrics": metrics.Handler(server.Dir),
+	})
+}
+
+// monitoring serves GET and HEAD requests for the given paths ahead of the
+// S3 API, without authentication or access logging. Buckets cannot take
+// these names, see validator.ValidateBucketName.
+func monitoring(api http.Handler, endpoints map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, ok := endpoints[r.URL.Path]
+		if ok && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			endpoint.ServeHTTP(w, r)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
+
+// escapeObjectKeys keeps object keys away from the path cleaning of
+// http.ServeMux, which would redirect keys containing "//", "." or ".."
+// segments. The key is re-escaped as a single path segment, so the route
+// still matches and r.PathValue returns the key unchanged.
+func escapeObjectKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, key, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if ok && key != "" {
+			r.URL.RawPath = "/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// orBucket sends requests for /{bucketName}/, with an empty key, to the
+// bucket handler. Some clients add the trailing slash to bucket requests.
+func orBucket(bucket, object http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("objectKey") == "" {
+			bucket(w, r)
+			return
+		}
+		object(w, r)
+	}
+}
+
+// subresource routes requests carrying the named query parameter, such as
+// ?uploads or ?uploadId, to a dedicated handler.
+type subresource struct {
+	name    string
+	handler http.HandlerFunc
+}
 
-	return mux
+// bySubresource picks the handler of the first subresource present in the
+// query string, falling back to def. A nil def rejects requests without a
+// known subresource.
+func bySubresource(def http.HandlerFunc, subresources ...subresource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, sub := range subresources {
+			if query.Has(sub.name) {
+				sub.handler(w, r)
+				return
+			}
+		}
+		if def == nil {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		def(w, r)
+	}
 }
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"triple-s/internal/metadb"
+)
+
+var ErrNoSuchBucketPolicy = errors.New("bucket has no policy")
+
+// BucketAccess is what decides who may use a bucket besides its owner.
+type BucketAccess struct {
+	Owner  string
+	ACL    string
+	Policy string
+}
+
+func GetBucketAccess(dataDir, bucketName string) (BucketAccess, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return BucketAccess{}, err
+	}
+
+	var access BucketAccess
+	err = db.View(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		access = BucketAccess{Owner: bucket.Owner, ACL: bucket.ACL, Policy: bucket.Policy}
+		return nil
+	})
+	return access, err
+}
+
+// ClaimOwnerlessBuckets makes owner the owner of every bucket that has
+// none, such as buckets created while the server had no credentials, and
+// returns their names. With an empty owner it only lists them.
+func ClaimOwnerlessBuckets(dataDir, owner string) ([]string, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := []string{}
+	err = db.Update(func(tx *metadb.Tx) error {
+		var err error
+		ownerless := []bucketRecord{}
+		tx.Ascend(bucketKeyPrefix, "", func(_ string, value []byte) bool {
+			bucket := bucketRecord{}
+			err = json.Unmarshal(value, &bucket)
+			if err != nil {
+				return false
+			}
+			if bucket.Owner == "" {
+				ownerless = append(ownerless, bucket)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, bucket := range ownerless {
+			claimed = append(claimed, bucket.Name)
+			if owner == "" {
+				continue
+			}
+			bucket.Owner = owner
+			err = putBucket(tx, bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+func GetBucketPolicy(dataDir, bucketName string) (string, error) {
+	access, err := GetBucketAccess(dataDir, bucketName)
+	if err != nil {
+		return "", err
+	}
+	if access.Policy == "" {
+		return "", ErrNoSuchBucketPolicy
+	}
+	return access.Policy, nil
+}
+
+// PutBucketPolicy replaces the policy document of a bucket. An empty
+// document removes the policy.
+func PutBucketPolicy(dataDir, bucketName, policy string) error {
+	return updateBucket(dataDir, bucketName, func(bucket *bucketRecord) {
+		bucket.Policy = policy
+	})
+}
+
+func DeleteBucketPolicy(dataDir, bucketName string) error {
+	return PutBucketPolicy(dataDir, bucketName, "")
+}
+
+func PutBucketACL(dataDir, bucketName, acl string) error {
+	return updateBucket(dataDir, bucketName, func(bucket *bucketRecord) {
+		bucket.ACL = acl
+	})
+}
+
+func updateBucket(dataDir, bucketName string, update func(bucket *bucketRecord)) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		update(bucket)
+		bucket.LastModified = time.Now()
+		return putBucket(tx, *bucket)
+	})
+}
+
+// PutObjectACL sets the canned ACL of an object version, or of the current
+// version when versionID is empty.
+func PutObjectACL(dataDir, bucketName, objectKey, versionID, acl string) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		record, err := lookupObject(tx, bucketName, objectKey, versionID)
+		if err != nil {
+			return err
+		}
+		if record.DeleteMarker {
+			return ErrNoSuchKey
+		}
+		record.ACL = acl
+
+		if bucket.Versioning != "" {
+			err = putVersion(tx, bucketName, *record)
+			if err != nil {
+				return err
+			}
+		}
+
+		current, err := getObject(tx, bucketName, objectKey)
+		if err == ErrNoSuchKey {
+			return nil
+		}
+		if err != nil || current.VersionID != record.VersionID {
+			return err
+		}
+		return putObject(tx, bucketName, *record)
+	})
+}
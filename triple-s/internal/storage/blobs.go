@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"triple-s/internal/metadb"
+)
+
+// In deduplication mode object data is stored once per distinct content, as
+// <dataDir>/.blobs/<first two hex digits>/<SHA-256>, and every version of
+// every key with that content points to the same blob. The metadata store
+// counts the references to each blob under r/<SHA-256>; a blob is removed
+// once its count drops to zero.
+const (
+	blobsDir      = ".blobs"
+	blobRefPrefix = "r/"
+)
+
+var (
+	dedupMu   sync.Mutex
+	dedupDirs = map[string]bool{}
+)
+
+// EnableDeduplication makes new objects in dataDir share their data with
+// every other object with the same content. Objects stored before keep
+// their own files, and all objects are read and deleted the same way
+// whichever mode stored them.
+func EnableDeduplication(dataDir string) {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+	dedupDirs[filepath.Clean(dataDir)] = true
+}
+
+func deduplicating(dataDir string) bool {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+	return dedupDirs[filepath.Clean(dataDir)]
+}
+
+func blobPath(sum string) string {
+	return filepath.Join(blobsDir, sum[:2], sum)
+}
+
+// blobSum returns the SHA-256 of the blob stored at path, or false if path
+// is not a blob.
+func blobSum(path string) (string, bool) {
+	if !strings.HasPrefix(path, blobsDir+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Base(path), true
+}
+
+func blobRefs(tx *metadb.Tx, sum string) (int, error) {
+	value, ok := tx.Get(blobRefPrefix + sum)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.Atoi(string(value))
+}
+
+// retainBlob adds a reference to a blob and reports whether it is the
+// first, in which case the caller must store the blob's data.
+func retainBlob(tx *metadb.Tx, sum string) (bool, error) {
+	refs, err := blobRefs(tx, sum)
+	if err != nil {
+		return false, err
+	}
+	return refs == 0, tx.Put(blobRefPrefix+sum, []byte(strconv.Itoa(refs+1)))
+}
+
+// releaseData drops a reference to the data at path and returns path if
+// the data is no longer used, so the caller can remove it once the
+// transaction is committed. Data that is not a blob has a single user.
+func releaseData(tx *metadb.Tx, path string) (string, error) {
+	sum, ok := blobSum(path)
+	if !ok {
+		return path, nil
+	}
+
+	refs, err := blobRefs(tx, sum)
+	if err != nil {
+		return "", err
+	}
+	if refs > 1 {
+		return "", tx.Put(blobRefPrefix+sum, []byte(strconv.Itoa(refs-1)))
+	}
+	return path, tx.Delete(blobRefPrefix + sum)
+}
+
+// storeBlob moves a staged file into the blob store unless it already
+// holds that content.
+func storeBlob(dataDir string, tx *metadb.Tx, sum, staged string) (string, error) {
+	path := blobPath(sum)
+
+	created, err := retainBlob(tx, sum)
+	if err != nil || !created {
+		return path, err
+	}
+
+	err = os.MkdirAll(filepath.Join(dataDir, filepath.Dir(path)), 0o755)
+	if err != nil {
+		return "", err
+	}
+	return path, os.Rename(staged, filepath.Join(dataDir, path))
+}
+
+// removeBlob removes an unreferenced blob. A new upload of the same content
+// may have stored it again since it was released, so the reference count
+// is checked once more under the store's lock.
+func removeBlob(dataDir, path string) error {
+	sum, _ := blobSum(path)
+
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.View(func(tx *metadb.Tx) error {
+		if _, ok := tx.Get(blobRefPrefix + sum); ok {
+			return nil
+		}
+		return removeFile(dataDir, path)
+	})
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+
+	"triple-s/internal/metadb"
+)
+
+var ErrNoSuchCORSConfiguration = errors.New("bucket has no CORS configuration")
+
+// CORSRule lets browsers on the matching origins use the listed methods on
+// a bucket from other sites. Origins and headers may contain one * that
+// stands for any sequence of characters.
+type CORSRule struct {
+	ID             string   `json:"id,omitempty"`
+	AllowedOrigins []string `json:"allowedOrigins"`
+	AllowedMethods []string `json:"allowedMethods"`
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	ExposeHeaders  []string `json:"exposeHeaders,omitempty"`
+	// MaxAgeSeconds is how long browsers may cache a preflight response,
+	// or -1 when the rule does not say.
+	MaxAgeSeconds int `json:"maxAgeSeconds"`
+}
+
+func GetBucketCors(dataDir, bucketName string) ([]CORSRule, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []CORSRule
+	err = db.View(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		if len(bucket.CORS) == 0 {
+			return ErrNoSuchCORSConfiguration
+		}
+		rules = bucket.CORS
+		return nil
+	})
+	return rules, err
+}
+
+// PutBucketCors replaces the CORS rules of a bucket. No rules removes the
+// configuration.
+func PutBucketCors(dataDir, bucketName string, rules []CORSRule) error {
+	return updateBucket(dataDir, bucketName, func(bucket *bucketRecord) {
+		bucket.CORS = rules
+	})
+}
+
+func DeleteBucketCors(dataDir, bucketName string) error {
+	return PutBucketCors(dataDir, bucketName, nil)
+}
@@ -0,0 +1,464 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"triple-s/internal/structure"
+)
+
+// Encrypted data files are made of one or more sealed segments, one per
+// multipart upload part or a single one for other objects. A segment starts
+// with a random salt from which its AES-256-GCM key is derived from the
+// object's data key, followed by the data sealed in chunks of
+// sealedChunkSize bytes, each with its own tag. Chunks are numbered in the
+// nonce, so any byte range can be read by opening only the chunks that
+// hold it.
+const (
+	dataKeySize     = 32
+	segmentSaltSize = 16
+	sealedChunkSize = 64 << 10
+	sealedChunkTag  = 16
+
+	// SSEAlgorithm is the only encryption algorithm supported, for both
+	// server and customer keys.
+	SSEAlgorithm = "AES256"
+)
+
+var (
+	ErrEncryptionNotEnabled = errors.New("server-side encryption is not enabled")
+	ErrCustomerKeyRequired  = errors.New("object is encrypted with a customer-provided key")
+	ErrCustomerKeyMismatch  = errors.New("customer-provided key does not match the object's key")
+	ErrNotCustomerEncrypted = errors.New("object is not encrypted with a customer-provided key")
+)
+
+var (
+	masterKeysMu sync.Mutex
+	masterKeys   = map[string][]byte{}
+)
+
+// SSE selects how a new object is encrypted at rest. The zero value uses
+// the master key when encryption is enabled and stores the object in
+// plaintext otherwise.
+type SSE struct {
+	// S3 requires encryption with the master key.
+	S3 bool
+	// CustomerKey is a 256-bit key provided by the client (SSE-C). It is
+	// never stored; the client must provide it again to read the object.
+	CustomerKey []byte
+}
+
+type encryptionRecord struct {
+	// CustomerKeyMD5 is set for objects encrypted with a customer key.
+	CustomerKeyMD5 string `json:"customerKeyMD5,omitempty"`
+	// DataKey is the object's own key, sealed with the master key or the
+	// customer key.
+	DataKey []byte `json:"dataKey"`
+	// Segments are the plaintext sizes of the segments of a multipart
+	// object. Other objects are a single segment.
+	Segments []int64 `json:"segments,omitempty"`
+}
+
+// EnableEncryption encrypts new objects in dataDir with a master key read
+// from keyFile. A new random key is written to keyFile if it does not
+// exist yet; objects encrypted with it can only be read as long as the
+// file is kept.
+func EnableEncryption(dataDir, keyFile string) error {
+	encoded, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key := make([]byte, dataKeySize)
+		_, err = rand.Read(key)
+		if err != nil {
+			return err
+		}
+		encoded = []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+		err = os.WriteFile(keyFile, encoded, 0o600)
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(key) != dataKeySize {
+		return fmt.Errorf("%s must hold a base64-encoded 256-bit key", keyFile)
+	}
+
+	masterKeysMu.Lock()
+	defer masterKeysMu.Unlock()
+	masterKeys[filepath.Clean(dataDir)] = key
+	return nil
+}
+
+func masterKey(dataDir string) []byte {
+	masterKeysMu.Lock()
+	defer masterKeysMu.Unlock()
+	return masterKeys[filepath.Clean(dataDir)]
+}
+
+// CustomerKeyMD5 returns the base64 MD5 by which a customer key is
+// identified in requests and responses.
+func CustomerKeyMD5(key []byte) string {
+	sum := md5.Sum(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CheckCustomerKey reports whether customerKey, which is nil when the client
+// provided none, is the right one to read an object.
+func CheckCustomerKey(encryption structure.Encryption, customerKey []byte) error {
+	if encryption.SSECustomerKeyMD5 == "" {
+		if customerKey != nil {
+			return ErrNotCustomerEncrypted
+		}
+		return nil
+	}
+	if customerKey == nil {
+		return ErrCustomerKeyRequired
+	}
+	if subtle.ConstantTimeCompare([]byte(CustomerKeyMD5(customerKey)), []byte(encryption.SSECustomerKeyMD5)) != 1 {
+		return ErrCustomerKeyMismatch
+	}
+	return nil
+}
+
+// newEncryption picks the encryption of a new object and returns its
+// record and data key, or nil for both if the object is stored in
+// plaintext.
+func newEncryption(dataDir string, sse SSE) (*encryptionRecord, []byte, error) {
+	record := &encryptionRecord{}
+	keyEncryptionKey := sse.CustomerKey
+	if keyEncryptionKey != nil {
+		record.CustomerKeyMD5 = CustomerKeyMD5(keyEncryptionKey)
+	} else {
+		keyEncryptionKey = masterKey(dataDir)
+	}
+	if keyEncryptionKey == nil {
+		if sse.S3 {
+			return nil, nil, ErrEncryptionNotEnabled
+		}
+		return nil, nil, nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newGCM(keyEncryptionKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	record.DataKey = aead.Seal(nonce, nonce, dataKey, nil)
+
+	return record, dataKey, nil
+}
+
+// dataKey unseals the data key of an object, or returns nil if the object
+// is not encrypted.
+func (e *encryptionRecord) dataKey(dataDir string, customerKey []byte) ([]byte, error) {
+	err := CheckCustomerKey(e.info(), customerKey)
+	if err != nil || e == nil {
+		return nil, err
+	}
+
+	keyEncryptionKey := customerKey
+	if keyEncryptionKey == nil {
+		keyEncryptionKey = masterKey(dataDir)
+	}
+	if keyEncryptionKey == nil {
+		return nil, ErrEncryptionNotEnabled
+	}
+
+	aead, err := newGCM(keyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.DataKey) < aead.NonceSize() {
+		return nil, errors.New("malformed data key")
+	}
+	nonce, sealed := e.DataKey[:aead.NonceSize()], e.DataKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+func (e *encryptionRecord) info() structure.Encryption {
+	switch {
+	case e == nil:
+		return structure.Encryption{}
+	case e.CustomerKeyMD5 != "":
+		return structure.Encryption{SSECustomerKeyMD5: e.CustomerKeyMD5}
+	default:
+		return structure.Encryption{SSE: SSEAlgorithm}
+	}
+}
+
+// segments returns the plaintext sizes of the segments of an object of the
+// given size.
+func (e *encryptionRecord) segments(size int64) []int64 {
+	if len(e.Segments) == 0 {
+		return []int64{size}
+	}
+	return e.Segments
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentCipher derives the cipher of a segment from the object's data key
+// and the segment's salt.
+func segmentCipher(dataKey, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write(salt)
+	return newGCM(mac.Sum(nil))
+}
+
+func chunkNonce(aead cipher.AEAD, chunk int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(chunk))
+	return nonce
+}
+
+// sealedSize returns the size of a segment holding size bytes of plaintext.
+func sealedSize(size int64) int64 {
+	chunks := (size + sealedChunkSize - 1) / sealedChunkSize
+	return segmentSaltSize + size + chunks*sealedChunkTag
+}
+
+// openedSize is the inverse of sealedSize.
+func openedSize(size int64) int64 {
+	size -= segmentSaltSize
+	chunks := (size + sealedChunkSize + sealedChunkTag - 1) / (sealedChunkSize + sealedChunkTag)
+	return size - chunks*sealedChunkTag
+}
+
+// stageSealed stages body like stageFile, sealing it as one segment when
+// dataKey is not nil. expectedSize and the returned size are plaintext
+// sizes.
+func stageSealed(dir string, body io.Reader, expectedSize int64, verify func() error, dataKey []byte) (string, int64, error) {
+	if dataKey == nil {
+		return stageFile(dir, body, expectedSize, verify)
+	}
+
+	sealer, err := newSealReader(body, dataKey)
+	if err != nil {
+		return "", 0, err
+	}
+	if expectedSize >= 0 {
+		expectedSize = sealedSize(expectedSize)
+	}
+
+	staged, size, err := stageFile(dir, sealer, expectedSize, verify)
+	return staged, openedSize(size), err
+}
+
+// sealReader reads a segment sealing the plaintext read from src.
+type sealReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	chunk  int64
+	plain  []byte
+	sealed []byte
+	unread []byte
+	eof    bool
+}
+
+func newSealReader(src io.Reader, dataKey []byte) (*sealReader, error) {
+	salt := make([]byte, segmentSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := segmentCipher(dataKey, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealReader{
+		src:    src,
+		aead:   aead,
+		plain:  make([]byte, sealedChunkSize),
+		sealed: make([]byte, 0, sealedChunkSize+sealedChunkTag),
+		unread: salt,
+	}, nil
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.unread) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+
+		// The chunk is filled by hand rather than with io.ReadFull, which
+		// would hide an io.ErrUnexpectedEOF from a truncated body.
+		n := 0
+		for n < len(s.plain) {
+			read, err := s.src.Read(s.plain[n:])
+			n += read
+			if err == io.EOF {
+				s.eof = true
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		if n == 0 {
+			continue
+		}
+
+		s.unread = s.aead.Seal(s.sealed[:0], chunkNonce(s.aead, s.chunk), s.plain[:n], nil)
+		s.chunk++
+	}
+
+	n := copy(p, s.unread)
+	s.unread = s.unread[n:]
+	return n, nil
+}
+
+// ObjectData is the content of an object version. Encrypted objects are
+// decrypted as they are read.
+type ObjectData interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+type plainData struct {
+	*os.File
+	size int64
+}
+
+func (d plainData) Size() int64 {
+	return d.size
+}
+
+type segment struct {
+	// start is the offset of the segment's plaintext in the object and
+	// offset that of its first chunk in the file.
+	start, size, offset int64
+	aead                cipher.AEAD
+}
+
+// sealedData decrypts the chunks of an encrypted data file as they are
+// read. The last chunk read is kept, since sequential reads are usually
+// smaller than a chunk.
+type sealedData struct {
+	file     *os.File
+	size     int64
+	segments []segment
+
+	mu          sync.Mutex
+	cached      []byte
+	cachedChunk [2]int
+}
+
+func openSealed(file *os.File, dataKey []byte, sizes []int64) (*sealedData, error) {
+	data := &sealedData{file: file, cachedChunk: [2]int{-1, -1}}
+
+	var offset int64
+	salt := make([]byte, segmentSaltSize)
+	for _, size := range sizes {
+		_, err := file.ReadAt(salt, offset)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := segmentCipher(dataKey, salt)
+		if err != nil {
+			return nil, err
+		}
+
+		data.segments = append(data.segments, segment{
+			start:  data.size,
+			size:   size,
+			offset: offset + segmentSaltSize,
+			aead:   aead,
+		})
+		data.size += size
+		offset += sealedSize(size)
+	}
+	return data, nil
+}
+
+func (d *sealedData) Size() int64 {
+	return d.size
+}
+
+func (d *sealedData) Close() error {
+	return d.file.Close()
+}
+
+func (d *sealedData) ReadAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < d.size {
+		i := sort.Search(len(d.segments), func(i int) bool {
+			return d.segments[i].start+d.segments[i].size > off
+		})
+		within := off - d.segments[i].start
+		chunk := within / sealedChunkSize
+
+		plain, err := d.chunk(i, int(chunk))
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], plain[within-chunk*sealedChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *sealedData) chunk(segmentIndex, chunk int) ([]byte, error) {
+	if d.cachedChunk == [2]int{segmentIndex, chunk} {
+		return d.cached, nil
+	}
+
+	seg := d.segments[segmentIndex]
+	size := min(sealedChunkSize, seg.size-int64(chunk)*sealedChunkSize)
+	sealed := make([]byte, size+sealedChunkTag)
+	_, err := d.file.ReadAt(sealed, seg.offset+int64(chunk)*(sealedChunkSize+sealedChunkTag))
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := seg.aead.Open(sealed[:0], chunkNonce(seg.aead, int64(chunk)), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt object data: %w", err)
+	}
+
+	d.cached, d.cachedChunk = plain, [2]int{segmentIndex, chunk}
+	return plain, nil
+}
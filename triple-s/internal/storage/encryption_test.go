@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSealedSize(t *testing.T) {
+	sizes := []int64{0, 1, sealedChunkSize - 1, sealedChunkSize, sealedChunkSize + 1, 3*sealedChunkSize + 5}
+	for _, size := range sizes {
+		sealed := sealedSize(size)
+		if got := openedSize(sealed); got != size {
+			t.Errorf("openedSize(sealedSize(%d)) = %d", size, got)
+		}
+
+		plain := make([]byte, size)
+		sealer, err := newSealReader(bytes.NewReader(plain), testKey(t))
+		if err != nil {
+			t.Fatalf("newSealReader: %v", err)
+		}
+		written, err := io.Copy(io.Discard, sealer)
+		if err != nil {
+			t.Fatalf("seal %d bytes: %v", size, err)
+		}
+		if written != sealed {
+			t.Errorf("sealing %d bytes wrote %d, sealedSize = %d", size, written, sealed)
+		}
+	}
+}
+
+func TestSealedRoundTrip(t *testing.T) {
+	key := testKey(t)
+	// Segments as written by a multipart upload, each sealed on its own.
+	sizes := []int64{sealedChunkSize + 10, 7, 2 * sealedChunkSize}
+	plain, path := writeSegments(t, key, sizes)
+
+	data := openTestSealed(t, path, key, sizes)
+	defer data.Close()
+
+	if data.Size() != int64(len(plain)) {
+		t.Fatalf("Size = %d, want %d", data.Size(), len(plain))
+	}
+
+	got, err := io.ReadAll(io.NewSectionReader(data, 0, data.Size()))
+	if err != nil {
+		t.Fatalf("read all: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted data differs from the plaintext")
+	}
+
+	// Ranges within a chunk, across chunks and across segments.
+	ranges := [][2]int64{
+		{0, 1},
+		{sealedChunkSize - 3, 6},
+		{sealedChunkSize + 5, 10},
+		{sealedChunkSize + 8, sealedChunkSize + 4},
+		{int64(len(plain)) - 1, 1},
+	}
+	for _, rng := range ranges {
+		buf := make([]byte, rng[1])
+		n, err := data.ReadAt(buf, rng[0])
+		if err != nil || n != len(buf) {
+			t.Errorf("ReadAt(%d, %d) = %d, %v", rng[0], rng[1], n, err)
+			continue
+		}
+		if !bytes.Equal(buf, plain[rng[0]:rng[0]+rng[1]]) {
+			t.Errorf("ReadAt(%d, %d) returned the wrong bytes", rng[0], rng[1])
+		}
+	}
+
+	buf := make([]byte, 10)
+	n, err := data.ReadAt(buf, int64(len(plain))-4)
+	if n != 4 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v, want 4, io.EOF", n, err)
+	}
+}
+
+func TestSealedTamperingIsDetected(t *testing.T) {
+	key := testKey(t)
+	sizes := []int64{2*sealedChunkSize + 1}
+	_, path := writeSegments(t, key, sizes)
+
+	// Flip a byte of the second chunk.
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(segmentSaltSize + sealedChunkSize + sealedChunkTag + 100)
+	b := make([]byte, 1)
+	file.ReadAt(b, offset)
+	b[0] ^= 1
+	file.WriteAt(b, offset)
+	file.Close()
+
+	data := openTestSealed(t, path, key, sizes)
+	defer data.Close()
+
+	buf := make([]byte, 10)
+	_, err = data.ReadAt(buf, 0)
+	if err != nil {
+		t.Errorf("read intact chunk: %v", err)
+	}
+	_, err = data.ReadAt(buf, sealedChunkSize+50)
+	if err == nil {
+		t.Error("read of a tampered chunk succeeded")
+	}
+
+	other := openTestSealed(t, path, testKey(t), sizes)
+	defer other.Close()
+	_, err = other.ReadAt(buf, 0)
+	if err == nil {
+		t.Error("read with the wrong key succeeded")
+	}
+}
+
+func TestSealReaderPassesErrors(t *testing.T) {
+	failure := errors.New("connection reset")
+	sealer, err := newSealReader(io.MultiReader(bytes.NewReader(make([]byte, 100)), iotest.ErrReader(failure)), testKey(t))
+	if err != nil {
+		t.Fatalf("newSealReader: %v", err)
+	}
+	_, err = io.Copy(io.Discard, sealer)
+	if !errors.Is(err, failure) {
+		t.Errorf("seal error = %v, want %v", err, failure)
+	}
+}
+
+func TestDataKey(t *testing.T) {
+	dataDir := t.TempDir()
+	customerKey := testKey(t)
+
+	record, dataKey, err := newEncryption(dataDir, SSE{CustomerKey: customerKey})
+	if err != nil {
+		t.Fatalf("newEncryption: %v", err)
+	}
+	got, err := record.dataKey(dataDir, customerKey)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("dataKey = %x, %v, want %x", got, err, dataKey)
+	}
+	_, err = record.dataKey(dataDir, testKey(t))
+	if !errors.Is(err, ErrCustomerKeyMismatch) {
+		t.Errorf("dataKey with another key error = %v, want ErrCustomerKeyMismatch", err)
+	}
+	_, err = record.dataKey(dataDir, nil)
+	if !errors.Is(err, ErrCustomerKeyRequired) {
+		t.Errorf("dataKey without a key error = %v, want ErrCustomerKeyRequired", err)
+	}
+
+	_, _, err = newEncryption(dataDir, SSE{S3: true})
+	if !errors.Is(err, ErrEncryptionNotEnabled) {
+		t.Errorf("SSE-S3 without a master key error = %v, want ErrEncryptionNotEnabled", err)
+	}
+	err = EnableEncryption(dataDir, filepath.Join(t.TempDir(), "master.key"))
+	if err != nil {
+		t.Fatalf("EnableEncryption: %v", err)
+	}
+	record, dataKey, err = newEncryption(dataDir, SSE{S3: true})
+	if err != nil {
+		t.Fatalf("newEncryption: %v", err)
+	}
+	got, err = record.dataKey(dataDir, nil)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("dataKey = %x, %v, want %x", got, err, dataKey)
+	}
+}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, dataKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeSegments seals random plaintext of the given segment sizes into one
+// file and returns the plaintext and the file path.
+func writeSegments(t *testing.T, key []byte, sizes []int64) ([]byte, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "data")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	plain := []byte{}
+	for _, size := range sizes {
+		segment := make([]byte, size)
+		rand.Read(segment)
+		plain = append(plain, segment...)
+
+		sealer, err := newSealReader(bytes.NewReader(segment), key)
+		if err != nil {
+			t.Fatalf("newSealReader: %v", err)
+		}
+		_, err = io.Copy(file, sealer)
+		if err != nil {
+			t.Fatalf("seal segment: %v", err)
+		}
+	}
+	return plain, path
+}
+
+func openTestSealed(t *testing.T, path string, key []byte, sizes []int64) *sealedData {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := openSealed(file, key, sizes)
+	if err != nil {
+		file.Close()
+		t.Fatalf("openSealed: %v", err)
+	}
+	return data
+}
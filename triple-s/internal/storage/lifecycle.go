@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"triple-s/internal/metadb"
+)
+
+const day = 24 * time.Hour
+
+// lifecycleBatchSize is the most records a lifecycle pass scans, and so
+// removes, in one transaction.
+const lifecycleBatchSize = 500
+
+var ErrNoSuchLifecycleConfiguration = errors.New("bucket has no lifecycle configuration")
+
+// LifecycleRule removes the objects of a bucket whose key starts with
+// Prefix once they reach a given age. Actions with a zero number of days
+// are not taken.
+type LifecycleRule struct {
+	ID      string `json:"id,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Enabled bool   `json:"enabled"`
+	// ExpirationDays is the age at which current versions expire. In a
+	// versioned bucket expiring adds a delete marker.
+	ExpirationDays int `json:"expirationDays,omitempty"`
+	// NoncurrentDays is how long a version is kept once a newer one
+	// replaced it, after which it is removed for good.
+	NoncurrentDays int `json:"noncurrentDays,omitempty"`
+	// AbortUploadDays is the age at which incomplete multipart uploads are
+	// aborted.
+	AbortUploadDays int `json:"abortUploadDays,omitempty"`
+}
+
+func GetBucketLifecycle(dataDir, bucketName string) ([]LifecycleRule, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []LifecycleRule
+	err = db.View(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		if len(bucket.Lifecycle) == 0 {
+			return ErrNoSuchLifecycleConfiguration
+		}
+		rules = bucket.Lifecycle
+		return nil
+	})
+	return rules, err
+}
+
+// PutBucketLifecycle replaces the lifecycle rules of a bucket. No rules
+// removes the configuration.
+func PutBucketLifecycle(dataDir, bucketName string, rules []LifecycleRule) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		bucket.Lifecycle = rules
+		bucket.LastModified = time.Now()
+		return putBucket(tx, *bucket)
+	})
+}
+
+func DeleteBucketLifecycle(dataDir, bucketName string) error {
+	return PutBucketLifecycle(dataDir, bucketName, nil)
+}
+
+// ApplyLifecycle enforces the lifecycle rules of every bucket as of now and
+// logs what was removed.
+func ApplyLifecycle(dataDir string, now time.Time) error {
+	buckets, err := ListBuckets(dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		err = applyBucketLifecycle(dataDir, bucket.Name, now)
+		// The bucket may have been deleted in the meantime.
+		if err != nil && !errors.Is(err, ErrNoSuchBucket) {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyBucketLifecycle(dataDir, bucketName string, now time.Time) error {
+	rules, err := GetBucketLifecycle(dataDir, bucketName)
+	if errors.Is(err, ErrNoSuchLifecycleConfiguration) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if rule.ExpirationDays > 0 {
+			err = expireObjects(dataDir, bucketName, rule, now.Add(-time.Duration(rule.ExpirationDays)*day))
+			if err != nil {
+				return err
+			}
+		}
+		if rule.NoncurrentDays > 0 {
+			err = expireNoncurrentVersions(dataDir, bucketName, rule, now.Add(-time.Duration(rule.NoncurrentDays)*day))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return abortLifecycleUploads(dataDir, bucketName, rules, now)
+}
+
+// expireObjects expires the current versions of the keys starting with the
+// prefix of rule that were last modified before cutoff. Keys are scanned
+// and expired lifecycleBatchSize at a time, each batch in its own
+// transaction, so that requests are never held up for long.
+func expireObjects(dataDir, bucketName string, rule LifecycleRule, cutoff time.Time) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	after := ""
+	for {
+		keys := []string{}
+		scanned := 0
+		err = db.View(func(tx *metadb.Tx) error {
+			return walkObjects(tx, bucketName, rule.Prefix, after, func(record objectRecord) bool {
+				scanned++
+				after = record.Key
+				if !record.LastModified.After(cutoff) {
+					keys = append(keys, record.Key)
+				}
+				return scanned < lifecycleBatchSize
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		removed := []string{}
+		garbage := []string{}
+		err = db.Update(func(tx *metadb.Tx) error {
+			bucket, err := getBucket(tx, bucketName)
+			if err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				// The object may have been replaced or deleted since it
+				// was scanned.
+				record, err := getObject(tx, bucketName, key)
+				if errors.Is(err, ErrNoSuchKey) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if record.LastModified.After(cutoff) {
+					continue
+				}
+
+				_, path, err := deleteObject(tx, bucket, key, "")
+				if err != nil {
+					return err
+				}
+				if path != "" {
+					garbage = append(garbage, path)
+				}
+				removed = append(removed, fmt.Sprintf("expired %s/%s (rule %q)", bucketName, key, rule.ID))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = finishLifecycleBatch(dataDir, garbage, removed)
+		if err != nil || scanned < lifecycleBatchSize {
+			return err
+		}
+	}
+}
+
+// expireNoncurrentVersions removes for good the versions of the keys
+// starting with the prefix of rule that were replaced by a newer version
+// before cutoff, in batches like expireObjects.
+func expireNoncurrentVersions(dataDir, bucketName string, rule LifecycleRule, cutoff time.Time) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	after := ""
+	// newer is the version scanned last, which is the next newer version
+	// of the one scanned after it when both have the same key.
+	var newer *objectRecord
+	for {
+		noncurrent := []objectRecord{}
+		scanned := 0
+		err = db.View(func(tx *metadb.Tx) error {
+			var err error
+			tx.Ascend(versionKeyPrefix+bucketName+"/"+rule.Prefix, after, func(key string, value []byte) bool {
+				record := objectRecord{}
+				err = json.Unmarshal(value, &record)
+				if err != nil {
+					return false
+				}
+				scanned++
+				after = key
+				// Versions are listed newest first, so a version became
+				// noncurrent when the one listed before it was written.
+				if newer != nil && newer.Key == record.Key && !newer.LastModified.After(cutoff) {
+					noncurrent = append(noncurrent, record)
+				}
+				newer = &record
+				return scanned < lifecycleBatchSize
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		removed := []string{}
+		garbage := []string{}
+		err = db.Update(func(tx *metadb.Tx) error {
+			bucket, err := getBucket(tx, bucketName)
+			if err != nil {
+				return err
+			}
+			if bucket.Versioning == "" {
+				return nil
+			}
+
+			for _, record := range noncurrent {
+				// Deleting the versions above it may have made the version
+				// current again since it was scanned.
+				current, err := getObject(tx, bucketName, record.Key)
+				if err == nil && current.VersionID == record.VersionID {
+					continue
+				}
+				if err != nil && !errors.Is(err, ErrNoSuchKey) {
+					return err
+				}
+
+				_, path, err := deleteObject(tx, bucket, record.Key, record.VersionID)
+				if errors.Is(err, ErrNoSuchVersion) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if path != "" {
+					garbage = append(garbage, path)
+				}
+				removed = append(removed, fmt.Sprintf("removed version %s of %s/%s (rule %q)", record.VersionID, bucketName, record.Key, rule.ID))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = finishLifecycleBatch(dataDir, garbage, removed)
+		if err != nil || scanned < lifecycleBatchSize {
+			return err
+		}
+	}
+}
+
+// finishLifecycleBatch removes the data released by a committed batch and
+// logs what the batch removed.
+func finishLifecycleBatch(dataDir string, garbage, removed []string) error {
+	for _, path := range garbage {
+		err := removeGarbage(dataDir, path)
+		if err != nil {
+			return err
+		}
+	}
+	for _, line := range removed {
+		log.Printf("Lifecycle: %s", line)
+	}
+	return nil
+}
+
+func abortLifecycleUploads(dataDir, bucketName string, rules []LifecycleRule, now time.Time) error {
+	uploads, err := ListMultipartUploads(dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		if upload.BucketName != bucketName {
+			continue
+		}
+		for _, rule := range rules {
+			if !rule.Enabled || rule.AbortUploadDays == 0 || !strings.HasPrefix(upload.ObjectKey, rule.Prefix) {
+				continue
+			}
+			if upload.Initiated.After(now.Add(-time.Duration(rule.AbortUploadDays) * day)) {
+				continue
+			}
+
+			err = AbortMultipartUpload(dataDir, upload.UploadID)
+			if err != nil {
+				return err
+			}
+			log.Printf("Lifecycle: aborted upload %s of %s/%s (rule %q)", upload.UploadID, bucketName, upload.ObjectKey, rule.ID)
+			break
+		}
+	}
+	return nil
+}
+
+// StartLifecycleWorker periodically enforces the lifecycle rules of every
+// bucket in the background.
+func StartLifecycleWorker(dataDir string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			err := ApplyLifecycle(dataDir, time.Now())
+			if err != nil {
+				log.Printf("Failed to apply lifecycle rules: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"triple-s/internal/metadb"
+	"triple-s/internal/structure"
+)
+
+// Bucket and object metadata lives in an embedded store under
+// <dataDir>/.metadata. Keys are laid out so that the ordered index lists a
+// bucket's objects by key:
+//
+//	b/<bucket>                bucketRecord
+//	o/<bucket>/<key>          objectRecord of the current version
+//	v/<bucket>/<key>\x00<seq>  objectRecord of every version, newest first
+//	r/<sha256>                reference count of a shared blob
+//	n/<id>                    Notification waiting for delivery
+//	m/<name>                  store bookkeeping
+const (
+	metadataDir = ".metadata"
+
+	bucketKeyPrefix       = "b/"
+	objectKeyPrefix       = "o/"
+	versionKeyPrefix      = "v/"
+	notificationKeyPrefix = "n/"
+	migratedKey           = "m/csv-migrated"
+	keysHashedKey         = "m/keys-hashed"
+	pendingMovesKey       = "m/pending-moves"
+)
+
+var (
+	ErrNoSuchBucket   = errors.New("bucket does not exist")
+	ErrBucketExists   = errors.New("bucket already exists")
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+	ErrNoSuchKey      = errors.New("object does not exist")
+	ErrNoSuchVersion  = errors.New("object version does not exist")
+)
+
+var (
+	databasesMu sync.Mutex
+	databases   = map[string]*metadb.DB{}
+)
+
+type bucketRecord struct {
+	Name         string    `json:"name"`
+	CreationTime time.Time `json:"created"`
+	LastModified time.Time `json:"modified"`
+	Status       string    `json:"status"`
+	// Versioning is empty until versioning is first enabled or suspended.
+	Versioning    string             `json:"versioning,omitempty"`
+	Lifecycle     []LifecycleRule    `json:"lifecycle,omitempty"`
+	CORS          []CORSRule         `json:"cors,omitempty"`
+	Notifications []NotificationRule `json:"notifications,omitempty"`
+	// Owner is the access key that created the bucket. Buckets created
+	// without authentication have none until ClaimOwnerlessBuckets gives
+	// them one.
+	Owner  string `json:"owner,omitempty"`
+	ACL    string `json:"acl,omitempty"`
+	Policy string `json:"policy,omitempty"`
+}
+
+type objectRecord struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"modified"`
+	ETag         string    `json:"etag,omitempty"`
+	// Path is the location of the object data relative to the data
+	// directory. It is empty for delete markers.
+	Path string `json:"path,omitempty"`
+
+	VersionID    string `json:"versionId,omitempty"`
+	DeleteMarker bool   `json:"deleteMarker,omitempty"`
+	// Seq orders the versions of a key, see versionSeq.
+	Seq string `json:"seq,omitempty"`
+
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	Expires            string            `json:"expires,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+
+	// Encryption is set for objects encrypted at rest.
+	Encryption *encryptionRecord `json:"encryption,omitempty"`
+	ACL        string            `json:"acl,omitempty"`
+}
+
+// Open opens the metadata store of dataDir, migrating the CSV files and
+// data file layout of older versions on first use. Storage functions open
+// it on demand, so calling Open is only needed to surface errors early.
+func Open(dataDir string) error {
+	_, err := metadata(dataDir)
+	return err
+}
+
+// Close closes the metadata store of dataDir if it is open, for good:
+// storage functions on dataDir fail with metadb.ErrClosed afterwards.
+func Close(dataDir string) error {
+	databasesMu.Lock()
+	db, ok := databases[filepath.Clean(dataDir)]
+	databasesMu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return db.Close()
+}
+
+func metadata(dataDir string) (*metadb.DB, error) {
+	dir := filepath.Clean(dataDir)
+
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	if db, ok := databases[dir]; ok {
+		return db, nil
+	}
+
+	db, err := metadb.Open(filepath.Join(dir, metadataDir))
+	if err != nil {
+		return nil, err
+	}
+	err = migrateCSV(dir, db)
+	if err == nil {
+		err = migrateKeyPaths(dir, db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	databases[dir] = db
+	return db, nil
+}
+
+func bucketIndexKey(bucketName string) string {
+	return bucketKeyPrefix + bucketName
+}
+
+func objectIndexPrefix(bucketName string) string {
+	return objectKeyPrefix + bucketName + "/"
+}
+
+func objectIndexKey(bucketName, key string) string {
+	return objectIndexPrefix(bucketName) + key
+}
+
+func (b bucketRecord) bucket() structure.Bucket {
+	return structure.Bucket{
+		Name:         b.Name,
+		CreationTime: b.CreationTime,
+		LastModified: b.LastModified,
+		Status:       b.Status,
+		Owner:        b.Owner,
+	}
+}
+
+func (o objectRecord) object() structure.Object {
+	return structure.Object{
+		ObjectKey:    o.Key,
+		Size:         o.Size,
+		LastModified: o.LastModified,
+		ETag:         o.ETag,
+		VersionID:    o.VersionID,
+		DeleteMarker: o.DeleteMarker,
+		ObjectHeaders: structure.ObjectHeaders{
+			ContentType:        o.ContentType,
+			ContentEncoding:    o.ContentEncoding,
+			ContentDisposition: o.ContentDisposition,
+			ContentLanguage:    o.ContentLanguage,
+			CacheControl:       o.CacheControl,
+			Expires:            o.Expires,
+			Metadata:           o.Metadata,
+		},
+		Encryption: o.Encryption.info(),
+		ACL:        o.ACL,
+	}
+}
+
+func newObjectRecord(object structure.Object, path string) objectRecord {
+	return objectRecord{
+		Key:          object.ObjectKey,
+		Size:         object.Size,
+		ContentType:  object.ContentType,
+		LastModified: object.LastModified,
+		ETag:         object.ETag,
+		Path:         path,
+
+		ContentEncoding:    object.ContentEncoding,
+		ContentDisposition: object.ContentDisposition,
+		ContentLanguage:    object.ContentLanguage,
+		CacheControl:       object.CacheControl,
+		Expires:            object.Expires,
+		Metadata:           object.Metadata,
+		ACL:                object.ACL,
+	}
+}
+
+func getBucket(tx *metadb.Tx, bucketName string) (*bucketRecord, error) {
+	value, ok := tx.Get(bucketIndexKey(bucketName))
+	if !ok {
+		return nil, ErrNoSuchBucket
+	}
+	record := &bucketRecord{}
+	return record, json.Unmarshal(value, record)
+}
+
+func putBucket(tx *metadb.Tx, record bucketRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Put(bucketIndexKey(record.Name), value)
+}
+
+func getObject(tx *metadb.Tx, bucketName, key string) (*objectRecord, error) {
+	value, ok := tx.Get(objectIndexKey(bucketName, key))
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	record := &objectRecord{}
+	return record, json.Unmarshal(value, record)
+}
+
+func putObject(tx *metadb.Tx, bucketName string, record objectRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Put(objectIndexKey(bucketName, record.Key), value)
+}
+
+// walkObjects calls fn for the objects of a bucket whose key starts with
+// prefix and sorts after after, in key order, until fn returns false.
+func walkObjects(tx *metadb.Tx, bucketName, prefix, after string, fn func(objectRecord) bool) error {
+	base := objectIndexPrefix(bucketName)
+	if after != "" {
+		after = base + after
+	}
+
+	var err error
+	tx.Ascend(base+prefix, after, func(_ string, value []byte) bool {
+		record := objectRecord{}
+		err = json.Unmarshal(value, &record)
+		if err != nil {
+			return false
+		}
+		return fn(record)
+	})
+	return err
+}
@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/internal/metadb"
+)
+
+// Before the metadata store, buckets were listed in <dataDir>/buckets.csv
+// and the objects of each bucket in <dataDir>/<bucket>/objects.csv.
+const (
+	bucketsCSV = "buckets.csv"
+	objectsCSV = "objects.csv"
+
+	// legacyDir keeps the migrated CSV files, relative to the metadata
+	// directory.
+	legacyDir = "legacy"
+)
+
+// migrateCSV imports the CSV metadata files into db in a single transaction
+// the first time the store is opened, then moves them out of the data
+// directory.
+func migrateCSV(dataDir string, db *metadb.DB) error {
+	migrated := false
+	err := db.View(func(tx *metadb.Tx) error {
+		_, migrated = tx.Get(migratedKey)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !migrated {
+		buckets, err := readBucketsCSV(dataDir)
+		if err != nil {
+			return err
+		}
+
+		objects := map[string][]objectRecord{}
+		for _, bucket := range buckets {
+			objects[bucket.Name], err = readObjectsCSV(dataDir, bucket.Name)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = db.Update(func(tx *metadb.Tx) error {
+			for _, bucket := range buckets {
+				err := putBucket(tx, bucket)
+				if err != nil {
+					return err
+				}
+				for _, object := range objects[bucket.Name] {
+					err = putObject(tx, bucket.Name, object)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Put(migratedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(buckets) > 0 {
+			log.Printf("Migrated %d buckets from %s to the metadata store", len(buckets), bucketsCSV)
+		}
+	}
+
+	// The files are moved only after the import is committed. Doing it on
+	// every start also finishes a move interrupted by a crash.
+	return moveLegacyFiles(dataDir)
+}
+
+// fileMove is a data file to move, with paths relative to the data
+// directory.
+type fileMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// migrateKeyPaths points the records of the data files that older versions
+// stored under <bucket>/<key> to the location given by keyPath, once, then
+// moves the files.
+func migrateKeyPaths(dataDir string, db *metadb.DB) error {
+	err := db.Update(func(tx *metadb.Tx) error {
+		if _, ok := tx.Get(keysHashedKey); ok {
+			return nil
+		}
+
+		type legacyRecord struct {
+			indexKey string
+			bucket   string
+			record   objectRecord
+		}
+		legacy := []legacyRecord{}
+		var err error
+		for _, prefix := range []string{objectKeyPrefix, versionKeyPrefix} {
+			tx.Ascend(prefix, "", func(indexKey string, value []byte) bool {
+				record := objectRecord{}
+				err = json.Unmarshal(value, &record)
+				if err != nil {
+					return false
+				}
+				bucket, _, _ := strings.Cut(strings.TrimPrefix(indexKey, prefix), "/")
+				if record.Path != "" && !strings.HasPrefix(record.Path, keyPath(bucket, record.Key)) && !strings.HasPrefix(record.Path, versionsDir+string(filepath.Separator)) {
+					legacy = append(legacy, legacyRecord{indexKey, bucket, record})
+				}
+				return true
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		moves := []fileMove{}
+		seen := map[string]bool{}
+		for _, l := range legacy {
+			target := keyPath(l.bucket, l.record.Key)
+			// The current and null version records share their file.
+			if !seen[l.record.Path] {
+				seen[l.record.Path] = true
+				moves = append(moves, fileMove{From: l.record.Path, To: target})
+			}
+
+			l.record.Path = target
+			value, err := json.Marshal(l.record)
+			if err != nil {
+				return err
+			}
+			err = tx.Put(l.indexKey, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(moves) > 0 {
+			value, err := json.Marshal(moves)
+			if err != nil {
+				return err
+			}
+			err = tx.Put(pendingMovesKey, value)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Put(keysHashedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return err
+	}
+
+	return finishMoves(dataDir, db)
+}
+
+// finishMoves moves the files listed by migrateKeyPaths. The files are only
+// moved once the records pointing to their new location are committed, and
+// the list is kept until every move is done, so a move interrupted by a
+// crash is finished on the next start. Files already moved are skipped.
+func finishMoves(dataDir string, db *metadb.DB) error {
+	var moves []fileMove
+	err := db.View(func(tx *metadb.Tx) error {
+		value, ok := tx.Get(pendingMovesKey)
+		if !ok {
+			return nil
+		}
+		return json.Unmarshal(value, &moves)
+	})
+	if err != nil || len(moves) == 0 {
+		return err
+	}
+
+	for _, move := range moves {
+		err = moveFile(filepath.Join(dataDir, move.From), filepath.Join(dataDir, move.To))
+		if err != nil {
+			return err
+		}
+
+		// Nested keys left directories behind. Removing them stops at the
+		// first one that is not empty.
+		for dir := filepath.Dir(move.From); strings.Contains(dir, string(filepath.Separator)); dir = filepath.Dir(dir) {
+			if os.Remove(filepath.Join(dataDir, dir)) != nil {
+				break
+			}
+		}
+	}
+	log.Printf("Moved %d object files to hashed names", len(moves))
+
+	return db.Update(func(tx *metadb.Tx) error {
+		return tx.Delete(pendingMovesKey)
+	})
+}
+
+func moveLegacyFiles(dataDir string) error {
+	legacy := filepath.Join(dataDir, metadataDir, legacyDir)
+
+	_, err := os.Stat(filepath.Join(dataDir, bucketsCSV))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	buckets, err := readBucketsCSV(dataDir)
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		err = moveFile(filepath.Join(dataDir, bucket.Name, objectsCSV), filepath.Join(legacy, bucket.Name, objectsCSV))
+		if err != nil {
+			return err
+		}
+	}
+
+	return moveFile(filepath.Join(dataDir, bucketsCSV), filepath.Join(legacy, bucketsCSV))
+}
+
+func moveFile(from, to string) error {
+	err := os.MkdirAll(filepath.Dir(to), 0o755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(from, to)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func readBucketsCSV(dataDir string) ([]bucketRecord, error) {
+	records, err := readCSV(filepath.Join(dataDir, bucketsCSV))
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []bucketRecord{}
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "Name" {
+			continue
+		}
+		if len(record) < 4 {
+			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
+			continue
+		}
+
+		creationTime, err := time.Parse(time.RFC3339, record[1])
+		if err != nil {
+			log.Printf("Failed to parse CreationTime in line %d: %v", i+1, err)
+			continue
+		}
+		modifiedTime, err := time.Parse(time.RFC3339, record[2])
+		if err != nil {
+			log.Printf("Failed to parse ModifiedTime in line %d: %v", i+1, err)
+			continue
+		}
+
+		buckets = append(buckets, bucketRecord{
+			Name:         record[0],
+			CreationTime: creationTime,
+			LastModified: modifiedTime,
+			Status:       record[3],
+		})
+	}
+
+	return buckets, nil
+}
+
+// readObjectsCSV returns the objects listed for a bucket, skipping rows
+// whose data file is missing.
+func readObjectsCSV(dataDir, bucketName string) ([]objectRecord, error) {
+	records, err := readCSV(filepath.Join(dataDir, bucketName, objectsCSV))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []objectRecord{}
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "ObjectKey" {
+			continue
+		}
+		if len(record) < 4 {
+			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
+			continue
+		}
+
+		size, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			log.Printf("Failed to parse Size in line %d: %v", i+1, err)
+			continue
+		}
+		lastModified, err := time.Parse(time.RFC3339, record[3])
+		if err != nil {
+			log.Printf("Failed to parse LastModified in line %d: %v", i+1, err)
+			continue
+		}
+
+		if !filepath.IsLocal(record[0]) {
+			log.Printf("Skipping object %s/%s with a key outside the bucket directory", bucketName, record[0])
+			continue
+		}
+		path := filepath.Join(bucketName, record[0])
+		_, err = os.Stat(filepath.Join(dataDir, path))
+		if err != nil {
+			log.Printf("Skipping object %s/%s without data: %v", bucketName, record[0], err)
+			continue
+		}
+
+		object := objectRecord{
+			Key:          record[0],
+			Size:         size,
+			ContentType:  record[2],
+			LastModified: lastModified,
+			Path:         path,
+		}
+		// Files written before the ETag column was added have four fields.
+		if len(record) > 4 {
+			object.ETag = record[4]
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+func readCSV(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	return reader.ReadAll()
+}
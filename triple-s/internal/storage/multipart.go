@@ -0,0 +1,543 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"triple-s/internal/structure"
+)
+
+const (
+	multipartDir = ".multipart"
+	uploadCSV    = "upload.csv"
+	partPrefix   = "part."
+	// etagSuffix names the ETag files of parts stored by older versions,
+	// which kept the ETag next to the part instead of in its file name.
+	etagSuffix = ".etag"
+
+	MinPartNumber = 1
+	MaxPartNumber = 10000
+	// MinPartSize is the smallest size allowed for any part but the last.
+	MinPartSize = 5 * 1024 * 1024
+)
+
+var (
+	ErrNoSuchUpload     = errors.New("upload does not exist")
+	ErrInvalidPart      = errors.New("part not found or ETag mismatch")
+	ErrInvalidPartOrder = errors.New("parts are not in ascending order")
+	ErrEntityTooSmall   = errors.New("part is smaller than the minimum allowed size")
+)
+
+type MultipartUpload struct {
+	UploadID   string
+	BucketName string
+	ObjectKey  string
+	Initiated  time.Time
+	// Headers are applied to the object once the upload completes.
+	Headers    structure.ObjectHeaders
+	Encryption structure.Encryption
+	ACL        string
+
+	// encryption is shared by the parts and the completed object.
+	encryption *encryptionRecord
+}
+
+type UploadedPart struct {
+	PartNumber   int
+	ETag         string
+	Size         int64
+	LastModified time.Time
+
+	// path is the file holding the part's data.
+	path string
+}
+
+// partsMu makes replacing a part, which renames the new file into place and
+// then removes the one it replaces, atomic to ListParts.
+var partsMu sync.Mutex
+
+func uploadDir(dataDir, uploadID string) string {
+	return filepath.Join(dataDir, multipartDir, uploadID)
+}
+
+// partName returns the file name of a part. The ETag is part of the name,
+// so that a single rename stores the data and its ETag together.
+func partName(partNumber int, etag string) string {
+	return fmt.Sprintf("%s%05d-%s", partPrefix, partNumber, etag)
+}
+
+// parsePartName returns the part number and ETag of a part file. The ETag
+// is empty for parts stored by older versions.
+func parsePartName(name string) (int, string, bool) {
+	rest, ok := strings.CutPrefix(name, partPrefix)
+	if !ok || strings.HasSuffix(name, etagSuffix) {
+		return 0, "", false
+	}
+	number, etag, _ := strings.Cut(rest, "-")
+	partNumber, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, "", false
+	}
+	return partNumber, etag, true
+}
+
+// CreateMultipartUpload allocates a staging directory for a new upload and
+// records which object it belongs to. Parts are encrypted as selected by
+// sse as soon as they are uploaded, and the object gets the canned ACL acl.
+func CreateMultipartUpload(dataDir, bucketName, objectKey string, headers structure.ObjectHeaders, sse SSE, acl string) (*MultipartUpload, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, _, err := newEncryption(dataDir, sse)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &MultipartUpload{
+		UploadID:   hex.EncodeToString(id),
+		BucketName: bucketName,
+		ObjectKey:  objectKey,
+		Initiated:  time.Now(),
+		Headers:    headers,
+		Encryption: encryption.info(),
+		ACL:        acl,
+		encryption: encryption,
+	}
+
+	encodedHeaders, err := json.Marshal(upload.Headers)
+	if err != nil {
+		return nil, err
+	}
+	encodedEncryption := []byte{}
+	if encryption != nil {
+		encodedEncryption, err = json.Marshal(encryption)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dir := uploadDir(dataDir, upload.UploadID)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(filepath.Join(dir, uploadCSV))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"UploadId", "Bucket", "Key", "ContentType", "Initiated", "Headers", "Encryption", "ACL"})
+	writer.Write([]string{
+		upload.UploadID,
+		upload.BucketName,
+		upload.ObjectKey,
+		upload.Headers.ContentType,
+		upload.Initiated.Format(time.RFC3339),
+		string(encodedHeaders),
+		string(encodedEncryption),
+		upload.ACL,
+	})
+	writer.Flush()
+
+	return upload, writer.Error()
+}
+
+// GetMultipartUpload loads an upload and checks that it targets the given
+// bucket and key.
+func GetMultipartUpload(dataDir, bucketName, objectKey, uploadID string) (*MultipartUpload, error) {
+	upload, err := readUpload(dataDir, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.BucketName != bucketName || upload.ObjectKey != objectKey {
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+func readUpload(dataDir, uploadID string) (*MultipartUpload, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return nil, ErrNoSuchUpload
+	}
+
+	file, err := os.Open(filepath.Join(uploadDir(dataDir, uploadID), uploadCSV))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchUpload
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 || len(records[1]) < 5 {
+		return nil, fmt.Errorf("malformed upload metadata for %s", uploadID)
+	}
+
+	record := records[1]
+	initiated, err := time.Parse(time.RFC3339, record[4])
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &MultipartUpload{
+		UploadID:   record[0],
+		BucketName: record[1],
+		ObjectKey:  record[2],
+		Initiated:  initiated,
+	}
+	// Uploads started by older versions have no Headers column.
+	if len(record) > 5 && record[5] != "" {
+		err = json.Unmarshal([]byte(record[5]), &upload.Headers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	upload.Headers.ContentType = record[3]
+	if len(record) > 6 && record[6] != "" {
+		err = json.Unmarshal([]byte(record[6]), &upload.encryption)
+		if err != nil {
+			return nil, err
+		}
+		upload.Encryption = upload.encryption.info()
+	}
+	if len(record) > 7 {
+		upload.ACL = record[7]
+	}
+
+	return upload, nil
+}
+
+// UploadPart stores one part of an upload, replacing any previous part with
+// the same number, and returns its ETag. customerKey must be given for
+// uploads encrypted with a customer key.
+func UploadPart(dataDir, uploadID string, partNumber int, body io.Reader, expectedSize int64, expectedMD5, customerKey []byte) (string, error) {
+	upload, err := readUpload(dataDir, uploadID)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := upload.encryption.dataKey(dataDir, customerKey)
+	if err != nil {
+		return "", err
+	}
+
+	dir := uploadDir(dataDir, uploadID)
+
+	hash := md5.New()
+	staged, _, err := stageSealed(dir, io.TeeReader(body, hash), expectedSize, verifyMD5(hash, expectedMD5), dataKey)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(staged)
+
+	etag := hex.EncodeToString(hash.Sum(nil))
+	name := partName(partNumber, etag)
+
+	partsMu.Lock()
+	defer partsMu.Unlock()
+
+	err = os.Rename(staged, filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+
+	// Drop the part this one replaces, which has another ETag.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		number, _, ok := parsePartName(entry.Name())
+		if ok && number == partNumber && entry.Name() != name {
+			err = os.Remove(filepath.Join(dir, entry.Name()))
+			if err == nil {
+				err = os.Remove(filepath.Join(dir, entry.Name()+etagSuffix))
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+
+	return etag, nil
+}
+
+// UploadPartCopy stores length bytes of a source object version, opened
+// with OpenObject, starting at offset, as one part of an upload.
+// customerKey is needed as for UploadPart.
+func UploadPartCopy(dataDir, uploadID string, partNumber int, source ObjectData, offset, length int64, customerKey []byte) (string, error) {
+	return UploadPart(dataDir, uploadID, partNumber, io.NewSectionReader(source, offset, length), length, nil, customerKey)
+}
+
+// ListParts returns the uploaded parts ordered by part number.
+func ListParts(dataDir, uploadID string) ([]UploadedPart, error) {
+	upload, err := readUpload(dataDir, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := uploadDir(dataDir, uploadID)
+
+	partsMu.Lock()
+	defer partsMu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchUpload
+		}
+		return nil, err
+	}
+
+	parts := []UploadedPart{}
+	for _, entry := range entries {
+		name := entry.Name()
+		partNumber, etag, ok := parsePartName(name)
+		if !ok {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if etag == "" {
+			legacy, err := os.ReadFile(filepath.Join(dir, name+etagSuffix))
+			if err != nil {
+				// The part was being written when the server stopped.
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			etag = string(legacy)
+		}
+
+		size := info.Size()
+		if upload.encryption != nil {
+			size = openedSize(size)
+		}
+
+		parts = append(parts, UploadedPart{
+			PartNumber:   partNumber,
+			ETag:         etag,
+			Size:         size,
+			LastModified: info.ModTime(),
+			path:         filepath.Join(dir, name),
+		})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload concatenates the requested parts into the final
+// object, records it like any other object and removes the staging area.
+// The ETag follows the S3 composite format: the MD5 of the concatenated
+// binary part MD5s, followed by a dash and the number of parts. Encrypted
+// parts are joined as they are, without decrypting them.
+func CompleteMultipartUpload(dataDir string, upload *MultipartUpload, requested []structure.CompletedPart) (structure.Object, error) {
+	if len(requested) == 0 {
+		return structure.Object{}, ErrInvalidPart
+	}
+
+	uploaded, err := ListParts(dataDir, upload.UploadID)
+	if err != nil {
+		return structure.Object{}, err
+	}
+	byNumber := make(map[int]UploadedPart, len(uploaded))
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+	}
+
+	composite := md5.New()
+	paths := make([]string, 0, len(requested))
+	sizes := make([]int64, 0, len(requested))
+	var total int64
+
+	for i, req := range requested {
+		if i > 0 && req.PartNumber <= requested[i-1].PartNumber {
+			return structure.Object{}, ErrInvalidPartOrder
+		}
+		part, ok := byNumber[req.PartNumber]
+		if !ok || strings.Trim(req.ETag, `"`) != part.ETag {
+			return structure.Object{}, ErrInvalidPart
+		}
+		if i < len(requested)-1 && part.Size < MinPartSize {
+			return structure.Object{}, ErrEntityTooSmall
+		}
+
+		sum, err := hex.DecodeString(part.ETag)
+		if err != nil {
+			return structure.Object{}, err
+		}
+		composite.Write(sum)
+
+		paths = append(paths, part.path)
+		sizes = append(sizes, part.Size)
+		total += part.Size
+	}
+
+	stored := total
+	var encryption *encryptionRecord
+	if upload.encryption != nil {
+		encryption = &encryptionRecord{
+			CustomerKeyMD5: upload.encryption.CustomerKeyMD5,
+			DataKey:        upload.encryption.DataKey,
+			Segments:       sizes,
+		}
+		stored = 0
+		for _, size := range sizes {
+			stored += sealedSize(size)
+		}
+	}
+
+	bucketDir := filepath.Join(dataDir, upload.BucketName)
+	err = os.MkdirAll(bucketDir, 0o755)
+	if err != nil {
+		return structure.Object{}, err
+	}
+
+	// Parts are streamed one at a time so that large uploads do not need a
+	// file descriptor per part.
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		for _, path := range paths {
+			file, err := os.Open(path)
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(writer, file)
+			file.Close()
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.Close()
+	}()
+
+	sum := sha256.New()
+	staged, _, err := stageFile(bucketDir, io.TeeReader(reader, sum), stored, nil)
+	if err != nil {
+		return structure.Object{}, err
+	}
+	defer os.Remove(staged)
+
+	object := structure.Object{
+		ObjectKey:     upload.ObjectKey,
+		Size:          total,
+		LastModified:  time.Now(),
+		ETag:          fmt.Sprintf("%s-%d", hex.EncodeToString(composite.Sum(nil)), len(requested)),
+		ObjectHeaders: upload.Headers,
+		ACL:           upload.ACL,
+	}
+
+	object, err = commitObject(dataDir, upload.BucketName, object, staged, hex.EncodeToString(sum.Sum(nil)), encryption)
+	if err != nil {
+		return structure.Object{}, err
+	}
+
+	return object, os.RemoveAll(uploadDir(dataDir, upload.UploadID))
+}
+
+func AbortMultipartUpload(dataDir, uploadID string) error {
+	return os.RemoveAll(uploadDir(dataDir, uploadID))
+}
+
+// ListMultipartUploads returns every upload in progress, in any bucket.
+func ListMultipartUploads(dataDir string) ([]MultipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(dataDir, multipartDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []MultipartUpload{}, nil
+		}
+		return nil, err
+	}
+
+	uploads := []MultipartUpload{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		upload, err := readUpload(dataDir, entry.Name())
+		if err != nil {
+			log.Printf("Skipping multipart upload %s: %v", entry.Name(), err)
+			continue
+		}
+		uploads = append(uploads, *upload)
+	}
+
+	return uploads, nil
+}
+
+// CleanupStaleUploads aborts uploads that were started more than maxAge ago
+// and returns how many were removed.
+func CleanupStaleUploads(dataDir string, maxAge time.Duration) (int, error) {
+	uploads, err := ListMultipartUploads(dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, upload := range uploads {
+		if upload.Initiated.After(cutoff) {
+			continue
+		}
+		err := AbortMultipartUpload(dataDir, upload.UploadID)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// StartUploadCleanup periodically aborts uploads older than maxAge in the
+// background.
+func StartUploadCleanup(dataDir string, interval, maxAge time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := CleanupStaleUploads(dataDir, maxAge)
+			if err != nil {
+				log.Printf("Failed to clean up stale multipart uploads: %v", err)
+			}
+			if removed > 0 {
+				log.Printf("Aborted %d stale multipart uploads", removed)
+			}
+		}
+	}()
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"triple-s/internal/metadb"
+)
+
+// NotificationRule sends the events of a bucket whose name matches one of
+// Events, and whose key starts with Prefix and ends with Suffix, to a
+// webhook.
+type NotificationRule struct {
+	ID       string   `json:"id,omitempty"`
+	Endpoint string   `json:"endpoint"`
+	Events   []string `json:"events"`
+	Prefix   string   `json:"prefix,omitempty"`
+	Suffix   string   `json:"suffix,omitempty"`
+}
+
+// Notification is an event waiting in the delivery queue.
+type Notification struct {
+	// ID orders the queue and is assigned by QueueNotifications.
+	ID       string          `json:"-"`
+	Endpoint string          `json:"endpoint"`
+	Payload  json.RawMessage `json:"payload"`
+	Queued   time.Time       `json:"queued"`
+	Attempts int             `json:"attempts,omitempty"`
+	// NextAttempt is when delivery may be tried again after a failure.
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+var (
+	notificationMu   sync.Mutex
+	lastNotification int64
+)
+
+// GetBucketNotification returns the notification rules of a bucket, which
+// are empty when none are configured.
+func GetBucketNotification(dataDir, bucketName string) ([]NotificationRule, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []NotificationRule
+	err = db.View(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		rules = bucket.Notifications
+		return nil
+	})
+	return rules, err
+}
+
+// PutBucketNotification replaces the notification rules of a bucket. No
+// rules turns notifications off.
+func PutBucketNotification(dataDir, bucketName string, rules []NotificationRule) error {
+	return updateBucket(dataDir, bucketName, func(bucket *bucketRecord) {
+		bucket.Notifications = rules
+	})
+}
+
+// QueueNotifications adds notifications to the delivery queue, in order.
+func QueueNotifications(dataDir string, notifications []Notification) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *metadb.Tx) error {
+		for _, notification := range notifications {
+			notification.ID = nextNotificationID()
+			err := putNotification(tx, notification)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DueNotifications returns up to limit queued notifications whose next
+// attempt is due at now, oldest first.
+func DueNotifications(dataDir string, now time.Time, limit int) ([]Notification, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	due := []Notification{}
+	err = db.View(func(tx *metadb.Tx) error {
+		var err error
+		tx.Ascend(notificationKeyPrefix, "", func(key string, value []byte) bool {
+			var notification Notification
+			err = json.Unmarshal(value, &notification)
+			if err != nil {
+				return false
+			}
+			if notification.NextAttempt.After(now) {
+				return true
+			}
+			notification.ID = key[len(notificationKeyPrefix):]
+			due = append(due, notification)
+			return len(due) < limit
+		})
+		return err
+	})
+	return due, err
+}
+
+// RescheduleNotification records a failed delivery attempt of a queued
+// notification.
+func RescheduleNotification(dataDir string, notification Notification) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *metadb.Tx) error {
+		return putNotification(tx, notification)
+	})
+}
+
+// DeleteNotification removes a notification from the queue once it is
+// delivered or given up on.
+func DeleteNotification(dataDir, id string) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *metadb.Tx) error {
+		return tx.Delete(notificationKeyPrefix + id)
+	})
+}
+
+func putNotification(tx *metadb.Tx, notification Notification) error {
+	value, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	return tx.Put(notificationKeyPrefix+notification.ID, value)
+}
+
+// nextNotificationID returns an ID that sorts after every ID handed out
+// so far.
+func nextNotificationID() string {
+	notificationMu.Lock()
+	defer notificationMu.Unlock()
+
+	now := time.Now().UnixNano()
+	if now <= lastNotification {
+		now = lastNotification + 1
+	}
+	lastNotification = now
+	return fmt.Sprintf("%016x", now)
+}
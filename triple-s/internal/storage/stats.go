@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+
+	"triple-s/internal/metadb"
+)
+
+// BucketStats counts the current objects of a bucket and their size.
+type BucketStats struct {
+	Name    string
+	Objects int64
+	Bytes   int64
+}
+
+// Stats returns the object counts of every bucket, in name order.
+func Stats(dataDir string) ([]BucketStats, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []BucketStats{}
+	err = db.View(func(tx *metadb.Tx) error {
+		var err error
+		tx.Ascend(bucketKeyPrefix, "", func(_ string, value []byte) bool {
+			bucket := bucketRecord{}
+			err = json.Unmarshal(value, &bucket)
+			if err != nil {
+				return false
+			}
+
+			bucketStats := BucketStats{Name: bucket.Name}
+			err = walkObjects(tx, bucket.Name, "", "", func(record objectRecord) bool {
+				if !record.DeleteMarker {
+					bucketStats.Objects++
+					bucketStats.Bytes += record.Size
+				}
+				return true
+			})
+			stats = append(stats, bucketStats)
+			return err == nil
+		})
+		return err
+	})
+	return stats, err
+}
+
+// Check reports whether the store can serve requests: its metadata can be
+// read and the data directory written to.
+func Check(dataDir string) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+	err = db.View(func(tx *metadb.Tx) error {
+		tx.Get(migratedKey)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dataDir, ".ready-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -1,451 +1,650 @@
 package storage
 
 import (
-	"encoding/csv"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"log"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
 	"time"
 
+	"triple-s/internal/metadb"
 	"triple-s/internal/structure"
 )
 
-const (
-	bucketsCSV = "buckets.csv"
-	objectsCSV = "objects.csv"
-)
-
-func CreateBucket(dataDir, bucketName string) error {
-	bucketDir := filepath.Join(dataDir, bucketName)
-	err := os.MkdirAll(bucketDir, 0o755)
+// CreateBucket creates a bucket owned by the given access key, which is
+// empty when requests are not authenticated, with a canned ACL.
+func CreateBucket(dataDir, bucketName, owner, acl string) error {
+	db, err := metadata(dataDir)
 	if err != nil {
 		return err
 	}
 
-	bucket := structure.Bucket{
-		Name:         bucketName,
-		CreationTime: time.Now(),
-		LastModified: time.Now(),
-		Status:       "active",
-	}
+	// The bucket directory is created by the first write to the bucket.
+	return db.Update(func(tx *metadb.Tx) error {
+		_, err := getBucket(tx, bucketName)
+		if err == nil {
+			return ErrBucketExists
+		}
 
-	return addBucketToCSV(dataDir, bucket)
+		now := time.Now()
+		return putBucket(tx, bucketRecord{
+			Name:         bucketName,
+			CreationTime: now,
+			LastModified: now,
+			Status:       "active",
+			Owner:        owner,
+			ACL:          acl,
+		})
+	})
 }
 
 func BucketExists(dataDir, bucketName string) (bool, error) {
-	buckets, err := ListBuckets(dataDir)
+	db, err := metadata(dataDir)
 	if err != nil {
 		return false, err
 	}
 
-	for _, bucket := range buckets {
-		if bucket.Name == bucketName {
-			return true, nil
-		}
-	}
-
-	return false, nil
+	exists := false
+	err = db.View(func(tx *metadb.Tx) error {
+		_, exists = tx.Get(bucketIndexKey(bucketName))
+		return nil
+	})
+	return exists, err
 }
 
+// ListBuckets returns every bucket ordered by name.
 func ListBuckets(dataDir string) ([]structure.Bucket, error) {
-	csvPath := filepath.Join(dataDir, bucketsCSV)
-
-	file, err := os.Open(csvPath)
+	db, err := metadata(dataDir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []structure.Bucket{}, nil
-		}
 		return nil, err
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	buckets := []structure.Bucket{}
+	err = db.View(func(tx *metadb.Tx) error {
+		var err error
+		tx.Ascend(bucketKeyPrefix, "", func(_ string, value []byte) bool {
+			record := bucketRecord{}
+			err = json.Unmarshal(value, &record)
+			if err != nil {
+				return false
+			}
+			buckets = append(buckets, record.bucket())
+			return true
+		})
+		return err
+	})
+	return buckets, err
+}
+
+// DeleteBucket removes an empty bucket and its directory.
+func DeleteBucket(dataDir, bucketName string) error {
+	db, err := metadata(dataDir)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	buckets := []structure.Bucket{}
-	for i, record := range records {
-		if i == 0 && len(record) > 0 && record[0] == "Name" {
-			continue
-		}
-		if len(record) < 4 {
-			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
-			continue
-		}
-
-		creationTime, err := time.Parse(time.RFC3339, record[1])
-		if err != nil {
-			log.Printf("Failed to parse CreationTime in line %d: %v", i+1, err)
-			continue
-		}
-		modifiedTime, err := time.Parse(time.RFC3339, record[2])
+	err = db.Update(func(tx *metadb.Tx) error {
+		_, err := getBucket(tx, bucketName)
 		if err != nil {
-			log.Printf("Failed to parse ModifiedTime in line %d: %v", i+1, err)
-			continue
+			return err
 		}
 
-		bucket := structure.Bucket{
-			Name:         record[0],
-			CreationTime: creationTime,
-			LastModified: modifiedTime,
-			Status:       record[3],
+		empty := true
+		tx.Ascend(objectIndexPrefix(bucketName), "", func(string, []byte) bool {
+			empty = false
+			return false
+		})
+		if !empty || hasVersions(tx, bucketName) {
+			return ErrBucketNotEmpty
 		}
-		buckets = append(buckets, bucket)
+
+		return tx.Delete(bucketIndexKey(bucketName))
+	})
+	if err != nil {
+		return err
 	}
 
-	return buckets, nil
+	// The directories are removed only once the deletion is committed, and
+	// only if the bucket has not been created again since, which cannot
+	// happen while the store is locked for reading.
+	return db.View(func(tx *metadb.Tx) error {
+		if _, ok := tx.Get(bucketIndexKey(bucketName)); ok {
+			return nil
+		}
+		err := os.RemoveAll(filepath.Join(dataDir, versionsDir, bucketName))
+		if err != nil {
+			return err
+		}
+		return os.RemoveAll(filepath.Join(dataDir, bucketName))
+	})
 }
 
-func DeleteBucket(dataDir, bucketName string) error {
-	bucketDir := filepath.Join(dataDir, bucketName)
-	err := os.RemoveAll(bucketDir)
+func IsBucketEmpty(dataDir, bucketName string) (bool, error) {
+	db, err := metadata(dataDir)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return removeBucketFromCSV(dataDir, bucketName)
+	empty := true
+	err = db.View(func(tx *metadb.Tx) error {
+		tx.Ascend(objectIndexPrefix(bucketName), "", func(string, []byte) bool {
+			empty = false
+			return false
+		})
+		return nil
+	})
+	return empty, err
 }
 
-func removeBucketFromCSV(dataDir, bucketName string) error {
-	csvPath := filepath.Join(dataDir, bucketsCSV)
+var (
+	ErrIncompleteBody = errors.New("request body size does not match Content-Length")
+	ErrBadDigest      = errors.New("Content-MD5 does not match the received body")
+)
+
+// StoreObject streams body into a temporary file next to the object and
+// renames it into place once fully written, so readers never see a partial
+// object. If expectedSize is not negative, the body must be exactly that
+// long, and if expectedMD5 is set the body must hash to it. The stored size
+// and ETag are taken from the bytes actually written, before they are
+// encrypted as selected by sse.
+func StoreObject(dataDir, bucketName, objectKey string, body io.Reader, expectedSize int64, expectedMD5 []byte, object structure.Object, sse SSE) (structure.Object, error) {
+	bucketDir := filepath.Join(dataDir, bucketName)
 
-	buckets, err := ListBuckets(dataDir)
+	err := os.MkdirAll(bucketDir, 0o755)
 	if err != nil {
-		return err
+		return structure.Object{}, err
 	}
 
-	filteredBuckets := []structure.Bucket{}
-	for _, bucket := range buckets {
-		if bucket.Name != bucketName {
-			filteredBuckets = append(filteredBuckets, bucket)
-		}
+	encryption, dataKey, err := newEncryption(dataDir, sse)
+	if err != nil {
+		return structure.Object{}, err
 	}
 
-	file, err := os.Create(csvPath)
+	hash, sum := md5.New(), sha256.New()
+	staged, size, err := stageSealed(bucketDir, io.TeeReader(body, io.MultiWriter(hash, sum)), expectedSize, verifyMD5(hash, expectedMD5), dataKey)
 	if err != nil {
-		return err
+		return structure.Object{}, err
 	}
-	defer file.Close()
+	defer os.Remove(staged)
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	object.Size = size
+	object.ETag = hex.EncodeToString(hash.Sum(nil))
 
-	writer.Write([]string{"Name", "CreationTime", "LastModifiedTime", "Status"})
+	return commitObject(dataDir, bucketName, object, staged, hex.EncodeToString(sum.Sum(nil)), encryption)
+}
 
-	for _, bucket := range filteredBuckets {
-		record := []string{
-			bucket.Name,
-			bucket.CreationTime.Format(time.RFC3339),
-			bucket.LastModified.Format(time.RFC3339),
-			bucket.Status,
+// CopyObject stores the data of a source object version, opened with
+// OpenObject, as a new object in dstBucket. The headers of the copy are
+// taken from object.
+func CopyObject(dataDir string, source ObjectData, dstBucket string, object structure.Object, sse SSE) (structure.Object, error) {
+	return StoreObject(dataDir, dstBucket, object.ObjectKey, io.NewSectionReader(source, 0, source.Size()), source.Size(), nil, object, sse)
+}
+
+func verifyMD5(h hash.Hash, expected []byte) func() error {
+	if expected == nil {
+		return nil
+	}
+	return func() error {
+		if !bytes.Equal(h.Sum(nil), expected) {
+			return ErrBadDigest
 		}
-		writer.Write(record)
+		return nil
 	}
-
-	return nil
 }
 
-func addBucketToCSV(dataDir string, bucket structure.Bucket) error {
-	csvPath := filepath.Join(dataDir, bucketsCSV)
-
-	needHeader := false
-	_, err := os.Stat(csvPath)
+// commitObject moves a staged data file with the given SHA-256 into place
+// and records the object's metadata in the same transaction. In a
+// versioned bucket the object becomes a new version; otherwise it replaces
+// the null version. encryption is nil for plaintext data.
+func commitObject(dataDir, bucketName string, object structure.Object, staged, sum string, encryption *encryptionRecord) (structure.Object, error) {
+	db, err := metadata(dataDir)
 	if err != nil {
-		if !os.IsNotExist(err) {
+		return structure.Object{}, err
+	}
+
+	var record objectRecord
+	replaced, placed := "", ""
+	err = db.Update(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
 			return err
 		}
-		needHeader = true
-	}
 
-	file, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		record = newObjectRecord(object, nullVersionPath(bucketName, object.ObjectKey))
+		record.Encryption = encryption
+		switch bucket.Versioning {
+		case VersioningEnabled:
+			record.VersionID, record.Seq = nextVersion()
+			record.Path = filepath.Join(versionsDir, bucketName, record.VersionID)
+		case VersioningSuspended:
+			replaced, err = removeNullVersion(tx, bucketName, object.ObjectKey)
+			record.VersionID = NullVersionID
+			_, record.Seq = nextVersion()
+		default:
+			old, err := getObject(tx, bucketName, object.ObjectKey)
+			if err == nil {
+				replaced = old.Path
+			} else if err != ErrNoSuchKey {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if replaced != "" {
+			replaced, err = releaseData(tx, replaced)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The data is moved to a path no other record uses before the
+		// metadata pointing to it is committed, so nothing is overwritten
+		// if the commit fails. Encrypted data differs even for the same
+		// content, so it is never shared.
+		if deduplicating(dataDir) && encryption == nil {
+			record.Path, err = storeBlob(dataDir, tx, sum, staged)
+		} else {
+			err = os.MkdirAll(filepath.Join(dataDir, filepath.Dir(record.Path)), 0o755)
+			if err == nil {
+				err = os.Rename(staged, filepath.Join(dataDir, record.Path))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		placed = record.Path
+		if bucket.Versioning != "" {
+			err = putVersion(tx, bucketName, record)
+			if err != nil {
+				return err
+			}
+		}
+		return putObject(tx, bucketName, record)
+	})
 	if err != nil {
-		return err
+		// Nothing refers to the data that was moved into place.
+		removeGarbage(dataDir, placed)
+		return structure.Object{}, err
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
-	if needHeader {
-		writer.Write([]string{"Name", "CreationTime", "LastModifiedTime", "Status"})
+	// Data paths are never reused, except for blobs, whose reference count
+	// removeGarbage checks again, so the replaced data can go.
+	err = removeGarbage(dataDir, replaced)
+	if err != nil {
+		return structure.Object{}, err
 	}
 
-	record := []string{
-		bucket.Name,
-		bucket.CreationTime.Format(time.RFC3339),
-		bucket.LastModified.Format(time.RFC3339),
-		bucket.Status,
+	return record.object(), nil
+}
+
+func ObjectExists(dataDir, bucketName, objectKey string) (bool, error) {
+	_, err := GetObjectMetadata(dataDir, bucketName, objectKey)
+	if errors.Is(err, ErrNoSuchKey) {
+		return false, nil
 	}
-	return writer.Write(record)
+	return err == nil, err
 }
 
-func IsBucketEmpty(dataDir, bucketName string) (bool, error) {
-	objects, err := listObjects(dataDir, bucketName)
+// writeFileAtomic streams body into a temporary file and renames it to path.
+// verify, if not nil, runs after the body has been fully read and can veto
+// the rename.
+func writeFileAtomic(path string, body io.Reader, expectedSize int64, verify func() error) (int64, error) {
+	staged, size, err := stageFile(filepath.Dir(path), body, expectedSize, verify)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
+	defer os.Remove(staged)
 
-	if len(objects) == 0 {
-		return true, err
-	}
-	return false, err
+	return size, os.Rename(staged, path)
 }
 
-func StoreObject(dataDir, bucketName, objectKey string, data []byte, object structure.Object) error {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-
-	err := os.MkdirAll(filepath.Dir(objectPath), 0o755)
+// stageFile streams body into a new temporary file in dir and returns its
+// path once the data is synced. The caller renames or removes it.
+func stageFile(dir string, body io.Reader, expectedSize int64, verify func() error) (string, int64, error) {
+	tmp, err := os.CreateTemp(dir, ".upload-*")
 	if err != nil {
-		return err
+		return "", 0, err
 	}
+	tmpPath := tmp.Name()
 
-	err = os.WriteFile(objectPath, data, 0o644)
-	if err != nil {
-		return err
+	size, err := io.Copy(tmp, body)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = ErrIncompleteBody
 	}
-
-	exists, err := objectExistsInCSV(dataDir, bucketName, objectKey)
-	if err != nil {
-		return err
+	if err == nil && expectedSize >= 0 && size != expectedSize {
+		err = ErrIncompleteBody
 	}
-
-	if exists {
-		return updateObjectInCSV(dataDir, bucketName, object)
+	if err == nil && verify != nil {
+		err = verify()
 	}
-
-	return addObjectToCSV(dataDir, bucketName, object)
-}
-
-func objectExistsInCSV(dataDir, bucketName, objectKey string) (bool, error) {
-	objects, err := listObjects(dataDir, bucketName)
-	if err != nil {
-		return false, err
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
 	}
 
-	for _, object := range objects {
-		if object.ObjectKey == objectKey {
-			return true, nil
-		}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", 0, err
 	}
 
-	return false, nil
+	return tmpPath, size, nil
 }
 
-func updateObjectInCSV(dataDir, bucketName string, updatedObject structure.Object) error {
-	objects, err := listObjects(dataDir, bucketName)
+// OpenObject opens the data of an object version, or of the current
+// version when versionID is empty, and returns it with the metadata of that
+// same version. customerKey must be given for objects encrypted with a
+// customer key. A delete marker fails with ErrNoSuchKey, but its metadata
+// is still returned.
+func OpenObject(dataDir, bucketName, objectKey, versionID string, customerKey []byte) (ObjectData, *structure.Object, error) {
+	record, err := getObjectRecord(dataDir, bucketName, objectKey, versionID)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-
-	for i, object := range objects {
-		if object.ObjectKey == updatedObject.ObjectKey {
-			objects[i] = updatedObject
-			break
-		}
+	object := record.object()
+	if record.DeleteMarker {
+		return nil, &object, ErrNoSuchKey
 	}
 
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
-	file, err := os.Create(csvPath)
+	dataKey, err := record.Encryption.dataKey(dataDir, customerKey)
 	if err != nil {
-		return err
+		return nil, &object, err
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	writer.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
 
-	for _, object := range objects {
-		record := []string{
-			object.ObjectKey,
-			strconv.FormatInt(object.Size, 10),
-			object.ContentType,
-			object.LastModified.Format(time.RFC3339),
-		}
-		writer.Write(record)
+	file, err := os.Open(filepath.Join(dataDir, record.Path))
+	if err != nil {
+		return nil, &object, err
+	}
+	if dataKey == nil {
+		return plainData{File: file, size: record.Size}, &object, nil
 	}
 
-	return nil
+	data, err := openSealed(file, dataKey, record.Encryption.segments(record.Size))
+	if err != nil {
+		file.Close()
+		return nil, &object, err
+	}
+	return data, &object, nil
 }
 
-func addObjectToCSV(dataDir, bucketName string, object structure.Object) error {
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
+func GetObjectMetadata(dataDir, bucketName, objectKey string) (*structure.Object, error) {
+	return GetObjectVersion(dataDir, bucketName, objectKey, "")
+}
 
-	needHeader := false
-	_, err := os.Stat(csvPath)
+// GetObjectVersion returns the metadata of a version, which may be a delete
+// marker, or of the current version when versionID is empty.
+func GetObjectVersion(dataDir, bucketName, objectKey, versionID string) (*structure.Object, error) {
+	record, err := getObjectRecord(dataDir, bucketName, objectKey, versionID)
 	if err != nil {
-		if os.IsNotExist(err) {
-			needHeader = true
-		} else {
-			return err
-		}
+		return nil, err
 	}
+	object := record.object()
+	return &object, nil
+}
 
-	file, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+func getObjectRecord(dataDir, bucketName, objectKey, versionID string) (*objectRecord, error) {
+	db, err := metadata(dataDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	var record *objectRecord
+	err = db.View(func(tx *metadb.Tx) error {
+		record, err = lookupObject(tx, bucketName, objectKey, versionID)
+		return err
+	})
+	return record, err
+}
 
-	if needHeader {
-		writer.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
+// lookupObject returns the record of an object version, or of the current
+// version when versionID is empty.
+func lookupObject(tx *metadb.Tx, bucketName, objectKey, versionID string) (*objectRecord, error) {
+	if versionID == "" {
+		return getObject(tx, bucketName, objectKey)
 	}
 
-	record := []string{
-		object.ObjectKey,
-		strconv.FormatInt(object.Size, 10),
-		object.ContentType,
-		object.LastModified.Format(time.RFC3339),
+	bucket, err := getBucket(tx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if bucket.Versioning != "" {
+		return findVersion(tx, bucketName, objectKey, versionID)
+	}
+	if versionID != NullVersionID {
+		return nil, ErrNoSuchVersion
 	}
+	record, err := getObject(tx, bucketName, objectKey)
+	if err == ErrNoSuchKey {
+		return nil, ErrNoSuchVersion
+	}
+	return record, err
+}
 
-	return writer.Write(record)
+// ListObjects returns the metadata of every object in the bucket ordered by
+// key.
+func ListObjects(dataDir, bucketName string) ([]structure.Object, error) {
+	objects := []structure.Object{}
+	err := WalkObjects(dataDir, bucketName, "", "", func(object structure.Object) bool {
+		objects = append(objects, object)
+		return true
+	})
+	return objects, err
 }
 
-func ObjectExists(dataDir, bucketName, objectKey string) (bool, error) {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-	_, err := os.Stat(objectPath)
+// WalkObjects calls fn for the objects of a bucket whose key starts with
+// prefix and sorts after after, in key order, until fn returns false. The
+// store is locked for reading meanwhile, so fn must not call back into it.
+func WalkObjects(dataDir, bucketName, prefix, after string, fn func(structure.Object) bool) error {
+	db, err := metadata(dataDir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		} else {
-			return false, err
-		}
+		return err
 	}
-	return true, nil
-}
 
-func GetObject(dataDir, bucketName, objectKey string) ([]byte, error) {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-	return os.ReadFile(objectPath)
+	return db.View(func(tx *metadb.Tx) error {
+		return walkObjects(tx, bucketName, prefix, after, func(record objectRecord) bool {
+			return fn(record.object())
+		})
+	})
 }
 
-func GetObjectMetadata(dataDir, bucketName, objectKey string) (*structure.Object, error) {
-	objects, err := listObjects(dataDir, bucketName)
+// DeleteObject deletes an object the way S3 does. Without a versionID, an
+// object in an unversioned bucket is removed, while a versioned bucket gets
+// a delete marker that becomes the current version. With a versionID, that
+// version is removed for good. The returned object describes the version
+// removed or the delete marker created.
+func DeleteObject(dataDir, bucketName, objectKey, versionID string) (structure.Object, error) {
+	db, err := metadata(dataDir)
 	if err != nil {
-		return nil, err
+		return structure.Object{}, err
 	}
 
-	for _, object := range objects {
-		if object.ObjectKey == objectKey {
-			return &object, nil
+	var deleted objectRecord
+	garbage := ""
+	err = db.Update(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
 		}
+		deleted, garbage, err = deleteObject(tx, bucket, objectKey, versionID)
+		return err
+	})
+	if err != nil {
+		return structure.Object{}, err
 	}
 
-	return nil, errors.New("object not found")
-}
-
-func listObjects(dataDir, bucketName string) ([]structure.Object, error) {
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
-
-	_, err := os.Stat(csvPath)
+	err = removeGarbage(dataDir, garbage)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []structure.Object{}, nil
-		} else {
-			return nil, err
-		}
+		return structure.Object{}, err
 	}
 
-	file, err := os.Open(csvPath)
+	return deleted.object(), nil
+}
+
+// ObjectDeletion is the outcome of deleting one key with DeleteObjects.
+type ObjectDeletion struct {
+	Key       string
+	VersionID string
+	// Deleted describes the version removed or the delete marker created
+	// when Err is nil and Existed is true.
+	Deleted structure.Object
+	// Existed is false when the key did not exist, in which case nothing
+	// was deleted even though the deletion succeeded.
+	Existed bool
+	Err     error
+}
+
+// DeleteObjects deletes several objects like DeleteObject, all in one
+// metadata transaction. Keys that cannot be deleted are reported in the
+// Err field of their result; deleting a key that does not exist succeeds,
+// as on S3.
+func DeleteObjects(dataDir, bucketName string, objects []structure.ObjectIdentifier) ([]ObjectDeletion, error) {
+	db, err := metadata(dataDir)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	results := make([]ObjectDeletion, 0, len(objects))
+	garbage := []string{}
+	err = db.Update(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range objects {
+			result := ObjectDeletion{Key: object.Key, VersionID: object.VersionID}
+
+			deleted, path, err := deleteObject(tx, bucket, object.Key, object.VersionID)
+			switch {
+			case err == nil:
+				result.Deleted = deleted.object()
+				result.Existed = true
+				if path != "" {
+					garbage = append(garbage, path)
+				}
+			case errors.Is(err, ErrNoSuchKey):
+				result.Deleted = structure.Object{ObjectKey: object.Key, VersionID: object.VersionID}
+			case errors.Is(err, ErrNoSuchVersion):
+				result.Err = err
+			default:
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	objects := []structure.Object{}
-	for i, record := range records {
-		if i == 0 && len(record) > 0 && record[0] == "ObjectKey" {
-			continue
+	for _, path := range garbage {
+		err = removeGarbage(dataDir, path)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if len(record) < 4 {
-			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
-			continue
-		}
+	return results, nil
+}
+
+// deleteObject applies DeleteObject to one key within tx and returns the
+// record deleted or created, and the path of data that is no longer
+// referenced, if any. The metadata is left untouched when it fails with
+// ErrNoSuchKey or ErrNoSuchVersion.
+func deleteObject(tx *metadb.Tx, bucket *bucketRecord, objectKey, versionID string) (objectRecord, string, error) {
+	bucketName := bucket.Name
 
-		size, err := strconv.ParseInt(record[1], 10, 64)
+	if bucket.Versioning == "" {
+		if versionID != "" && versionID != NullVersionID {
+			return objectRecord{}, "", ErrNoSuchVersion
+		}
+		record, err := getObject(tx, bucketName, objectKey)
 		if err != nil {
-			log.Printf("Failed to parse Size in line %d: %v", i+1, err)
-			continue
+			return objectRecord{}, "", err
 		}
-		lastModified, err := time.Parse(time.RFC3339, record[3])
+		deleted := *record
+		deleted.VersionID = versionID
+		garbage, err := releaseData(tx, record.Path)
 		if err != nil {
-			log.Printf("Failed to parse LastModified in line %d: %v", i+1, err)
-			continue
+			return objectRecord{}, "", err
 		}
+		return deleted, garbage, tx.Delete(objectIndexKey(bucketName, objectKey))
+	}
 
-		object := structure.Object{
-			ObjectKey:    record[0],
-			Size:         size,
-			ContentType:  record[2],
-			LastModified: lastModified,
+	if versionID != "" {
+		record, err := findVersion(tx, bucketName, objectKey, versionID)
+		if err != nil {
+			return objectRecord{}, "", err
 		}
-		objects = append(objects, object)
+		err = tx.Delete(versionIndexKey(bucketName, *record))
+		if err != nil {
+			return objectRecord{}, "", err
+		}
+		garbage := ""
+		if !record.DeleteMarker {
+			garbage, err = releaseData(tx, record.Path)
+			if err != nil {
+				return objectRecord{}, "", err
+			}
+		}
+		return *record, garbage, refreshCurrent(tx, bucketName, objectKey)
 	}
 
-	return objects, nil
-}
-
-func DeleteObject(dataDir, bucketName, objectKey string) error {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-
-	err := os.Remove(objectPath)
+	deleted := objectRecord{
+		Key:          objectKey,
+		LastModified: time.Now(),
+		DeleteMarker: true,
+	}
+	garbage := ""
+	var err error
+	if bucket.Versioning == VersioningEnabled {
+		deleted.VersionID, deleted.Seq = nextVersion()
+	} else {
+		garbage, err = removeNullVersion(tx, bucketName, objectKey)
+		if err == nil && garbage != "" {
+			garbage, err = releaseData(tx, garbage)
+		}
+		if err != nil {
+			return objectRecord{}, "", err
+		}
+		deleted.VersionID = NullVersionID
+		_, deleted.Seq = nextVersion()
+	}
+	err = putVersion(tx, bucketName, deleted)
 	if err != nil {
-		return err
+		return objectRecord{}, "", err
 	}
-
-	return removeObjectFromCSV(dataDir, bucketName, objectKey)
+	return deleted, garbage, tx.Delete(objectIndexKey(bucketName, objectKey))
 }
 
-func removeObjectFromCSV(dataDir, bucketName, objectKey string) error {
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
-
-	objects, err := listObjects(dataDir, bucketName)
-	if err != nil {
-		return err
+// removeGarbage removes the data of a deleted object. Data is removed only
+// once the metadata no longer points to it.
+func removeGarbage(dataDir, path string) error {
+	if path == "" {
+		return nil
 	}
-
-	filteredObjects := []structure.Object{}
-	for _, object := range objects {
-		if object.ObjectKey != objectKey {
-			filteredObjects = append(filteredObjects, object)
-		}
+	if _, ok := blobSum(path); ok {
+		return removeBlob(dataDir, path)
 	}
+	return removeFile(dataDir, path)
+}
 
-	file, err := os.Create(csvPath)
-	if err != nil {
+func removeFile(dataDir, path string) error {
+	err := os.Remove(filepath.Join(dataDir, path))
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	writer.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
-
-	for _, object := range filteredObjects {
-		record := []string{
-			object.ObjectKey,
-			strconv.FormatInt(object.Size, 10),
-			object.ContentType,
-			object.LastModified.Format(time.RFC3339),
-		}
-		writer.Write(record)
-	}
-
 	return nil
 }
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"triple-s/internal/metadb"
+	"triple-s/internal/structure"
+)
+
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+
+	// NullVersionID identifies the version written while versioning was not
+	// enabled.
+	NullVersionID = "null"
+
+	// versionsDir holds the data of versions other than the null version,
+	// as <dataDir>/.versions/<bucket>/<versionId>. The null version is
+	// kept in the bucket directory, see nullVersionPath.
+	versionsDir = ".versions"
+)
+
+var (
+	versionMu       sync.Mutex
+	lastVersionTime int64
+)
+
+func versionIndexPrefix(bucketName, key string) string {
+	return versionKeyPrefix + bucketName + "/" + key + "\x00"
+}
+
+func versionIndexKey(bucketName string, record objectRecord) string {
+	return versionIndexPrefix(bucketName, record.Key) + record.Seq
+}
+
+// versionSeq maps a time to a fixed width string that sorts newer times
+// first, so the index lists the versions of a key from the latest.
+func versionSeq(t time.Time) string {
+	return fmt.Sprintf("%016x", math.MaxUint64-uint64(t.UnixNano()))
+}
+
+// nextVersion returns a new version ID and a sequence that sorts before
+// every sequence handed out so far.
+func nextVersion() (string, string) {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
+	now := time.Now().UnixNano()
+	if now <= lastVersionTime {
+		now = lastVersionTime + 1
+	}
+	lastVersionTime = now
+
+	return rand.Text(), versionSeq(time.Unix(0, now))
+}
+
+// nullVersionPath returns a new location for the data of the null version
+// of a key, keyPath with a random suffix. Every write gets its own file, so
+// removing the data of a replaced version once its record is gone can never
+// remove the data of the write that replaced it.
+func nullVersionPath(bucketName, key string) string {
+	return keyPath(bucketName, key) + "-" + rand.Text()
+}
+
+// keyPath is the location of the data of a key within its bucket, before
+// every write got its own file.
+func keyPath(bucketName, key string) string {
+	return filepath.Join(bucketName, objectFileName(key))
+}
+
+// objectFileName maps an object key to the name of its data file. Keys are
+// arbitrary strings, so they are hashed rather than used as paths: the name
+// cannot escape the bucket directory, collide with another file or exceed
+// file name limits, and keys differing only in case stay apart on
+// case-insensitive file systems.
+func objectFileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func GetBucketVersioning(dataDir, bucketName string) (string, error) {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return "", err
+	}
+
+	status := ""
+	err = db.View(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		status = bucket.Versioning
+		return nil
+	})
+	return status, err
+}
+
+// SetBucketVersioning enables or suspends versioning. The first time, the
+// existing objects become null versions.
+func SetBucketVersioning(dataDir, bucketName, status string) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+
+		if bucket.Versioning == "" {
+			err = adoptNullVersions(tx, bucketName)
+			if err != nil {
+				return err
+			}
+		}
+
+		bucket.Versioning = status
+		bucket.LastModified = time.Now()
+		return putBucket(tx, *bucket)
+	})
+}
+
+func adoptNullVersions(tx *metadb.Tx, bucketName string) error {
+	records := []objectRecord{}
+	err := walkObjects(tx, bucketName, "", "", func(record objectRecord) bool {
+		records = append(records, record)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		record.VersionID = NullVersionID
+		record.Seq = versionSeq(record.LastModified)
+		err = putVersion(tx, bucketName, record)
+		if err != nil {
+			return err
+		}
+		err = putObject(tx, bucketName, record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putVersion(tx *metadb.Tx, bucketName string, record objectRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Put(versionIndexKey(bucketName, record), value)
+}
+
+// versions returns every version of a key, newest first.
+func versions(tx *metadb.Tx, bucketName, key string) ([]objectRecord, error) {
+	records := []objectRecord{}
+	var err error
+	tx.Ascend(versionIndexPrefix(bucketName, key), "", func(_ string, value []byte) bool {
+		record := objectRecord{}
+		err = json.Unmarshal(value, &record)
+		records = append(records, record)
+		return err == nil
+	})
+	return records, err
+}
+
+func findVersion(tx *metadb.Tx, bucketName, key, versionID string) (*objectRecord, error) {
+	records, err := versions(tx, bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.VersionID == versionID {
+			return &record, nil
+		}
+	}
+	return nil, ErrNoSuchVersion
+}
+
+// removeNullVersion drops the null version of a key, if there is one, and
+// returns its data path.
+func removeNullVersion(tx *metadb.Tx, bucketName, key string) (string, error) {
+	record, err := findVersion(tx, bucketName, key, NullVersionID)
+	if err == ErrNoSuchVersion {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return record.Path, tx.Delete(versionIndexKey(bucketName, *record))
+}
+
+// refreshCurrent points the current object record of a key at its newest
+// version, or removes it when that is a delete marker or there is none.
+func refreshCurrent(tx *metadb.Tx, bucketName, key string) error {
+	records, err := versions(tx, bucketName, key)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 || records[0].DeleteMarker {
+		return tx.Delete(objectIndexKey(bucketName, key))
+	}
+	return putObject(tx, bucketName, records[0])
+}
+
+// ListObjectVersions calls fn for every version and delete marker of the
+// objects whose key starts with prefix, ordered by key and then from the
+// newest version, until fn returns false. Listing resumes after keyMarker,
+// or after the version versionIDMarker of keyMarker when it is set. Objects
+// in buckets that never had versioning are listed as null versions.
+func ListObjectVersions(dataDir, bucketName, prefix, keyMarker, versionIDMarker string, fn func(object structure.Object, isLatest bool) bool) error {
+	db, err := metadata(dataDir)
+	if err != nil {
+		return err
+	}
+
+	return db.View(func(tx *metadb.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+
+		if bucket.Versioning == "" {
+			return walkObjects(tx, bucketName, prefix, keyMarker, func(record objectRecord) bool {
+				object := record.object()
+				object.VersionID = NullVersionID
+				return fn(object, true)
+			})
+		}
+
+		base := versionKeyPrefix + bucketName + "/"
+		after, lastKey := "", ""
+		if keyMarker != "" {
+			// Every version index key of keyMarker sorts below this one.
+			after = base + keyMarker + "\x00\xff"
+			if versionIDMarker != "" {
+				marker, err := findVersion(tx, bucketName, keyMarker, versionIDMarker)
+				if err != nil {
+					return err
+				}
+				after = versionIndexKey(bucketName, *marker)
+				lastKey = keyMarker
+			}
+		}
+
+		tx.Ascend(base+prefix, after, func(_ string, value []byte) bool {
+			record := objectRecord{}
+			err = json.Unmarshal(value, &record)
+			if err != nil {
+				return false
+			}
+			isLatest := record.Key != lastKey
+			lastKey = record.Key
+			return fn(record.object(), isLatest)
+		})
+		return err
+	})
+}
+
+// hasVersions reports whether any version or delete marker is left in a
+// bucket.
+func hasVersions(tx *metadb.Tx, bucketName string) bool {
+	found := false
+	tx.Ascend(versionKeyPrefix+bucketName+"/", "", func(string, []byte) bool {
+		found = true
+		return false
+	})
+	return found
+}
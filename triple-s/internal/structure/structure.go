@@ -2,12 +2,22 @@ package structure
 
 import (
 	"encoding/xml"
+	"io"
 	"time"
 )
 
 type Server struct {
 	Dir  string
 	Port string
+	// Credentials maps access key IDs to secret keys. Authentication is
+	// disabled when it is nil.
+	Credentials map[string]string
+	// AdminKey is the access key that owns buckets created without
+	// credentials and sees every bucket in ListBuckets.
+	AdminKey string
+	// AccessLog receives a line per request. Access logging is disabled
+	// when it is nil.
+	AccessLog io.Writer
 }
 
 type Owner struct {
@@ -20,6 +30,8 @@ type Bucket struct {
 	CreationTime time.Time `xml:"CreationTime"`
 	LastModified time.Time `xml:"LastModified"`
 	Status       string    `xml:"Status"`
+	// Owner is the access key that owns the bucket, empty for none.
+	Owner string `xml:"-"`
 }
 
 type Buckets struct {
@@ -35,8 +47,264 @@ type ListAllBuckets struct {
 type Object struct {
 	ObjectKey    string    `xml:"ObjectKey"`
 	Size         int64     `xml:"Size"`
-	ContentType  string    `xml:"ContentType"`
 	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	// VersionID is empty for objects in buckets that never had versioning
+	// enabled.
+	VersionID    string `xml:"VersionId,omitempty"`
+	DeleteMarker bool   `xml:"-"`
+	ObjectHeaders
+	Encryption `xml:"-"`
+	// ACL is the canned ACL of the object, empty for private.
+	ACL string `xml:"-"`
+}
+
+// Encryption describes how an object is encrypted at rest. It is empty for
+// objects stored in plaintext.
+type Encryption struct {
+	// SSE is "AES256" for objects encrypted with the server's master key.
+	SSE string
+	// SSECustomerKeyMD5 is the base64 MD5 of the key of an object encrypted
+	// with a key provided by the client (SSE-C).
+	SSECustomerKeyMD5 string
+}
+
+// ObjectHeaders are the headers given when an object is written that are
+// stored with it and returned whenever it is read.
+type ObjectHeaders struct {
+	ContentType        string `xml:"ContentType"`
+	ContentEncoding    string `xml:"-"`
+	ContentDisposition string `xml:"-"`
+	ContentLanguage    string `xml:"-"`
+	CacheControl       string `xml:"-"`
+	Expires            string `xml:"-"`
+	// Metadata holds the x-amz-meta-* headers keyed by their lowercase name
+	// without the prefix.
+	Metadata map[string]string `xml:"-"`
+}
+
+type CopyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+type Delete struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []ObjectIdentifier `xml:"Object"`
+}
+
+type ObjectIdentifier struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId"`
+}
+
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+type DeletedObject struct {
+	Key                   string `xml:"Key"`
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type DeleteError struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+}
+
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+type LifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []LifecycleRule `xml:"Rule"`
+}
+
+type LifecycleRule struct {
+	ID     string `xml:"ID,omitempty"`
+	Status string `xml:"Status"`
+	// Prefix is the deprecated form of Filter.
+	Prefix                         *string                         `xml:"Prefix"`
+	Filter                         *LifecycleFilter                `xml:"Filter"`
+	Expiration                     *LifecycleExpiration            `xml:"Expiration"`
+	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload"`
+}
+
+type LifecycleFilter struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type LifecycleExpiration struct {
+	Days int `xml:"Days"`
+}
+
+type NoncurrentVersionExpiration struct {
+	NoncurrentDays int `xml:"NoncurrentDays"`
+}
+
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+type CORSConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Rules   []CORSRule `xml:"CORSRule"`
+}
+
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader"`
+	ExposeHeaders  []string `xml:"ExposeHeader"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds"`
+}
+
+// NotificationConfiguration holds the webhooks of a bucket. S3 sends
+// notifications to AWS services instead, which have no equivalent here.
+type NotificationConfiguration struct {
+	XMLName  xml.Name               `xml:"NotificationConfiguration"`
+	Webhooks []WebhookConfiguration `xml:"WebhookConfiguration"`
+}
+
+type WebhookConfiguration struct {
+	ID       string              `xml:"Id,omitempty"`
+	Endpoint string              `xml:"Endpoint"`
+	Events   []string            `xml:"Event"`
+	Filter   *NotificationFilter `xml:"Filter"`
+}
+
+type NotificationFilter struct {
+	Rules []FilterRule `xml:"S3Key>FilterRule"`
+}
+
+type FilterRule struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+type ListVersionsResult struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	Name                string   `xml:"Name"`
+	Prefix              string   `xml:"Prefix"`
+	Delimiter           string   `xml:"Delimiter,omitempty"`
+	KeyMarker           string   `xml:"KeyMarker"`
+	VersionIDMarker     string   `xml:"VersionIdMarker"`
+	NextKeyMarker       string   `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string   `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int      `xml:"MaxKeys"`
+	IsTruncated         bool     `xml:"IsTruncated"`
+	// Versions holds ObjectVersion and DeleteMarkerEntry values in listing
+	// order.
+	Versions       []any          `xml:",any"`
+	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type ObjectVersion struct {
+	XMLName      xml.Name  `xml:"Version"`
+	Key          string    `xml:"Key"`
+	VersionID    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag,omitempty"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type DeleteMarkerEntry struct {
+	XMLName      xml.Name  `xml:"DeleteMarker"`
+	Key          string    `xml:"Key"`
+	VersionID    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type ListBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Contents              []ObjectContents `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix   `xml:"CommonPrefixes"`
+}
+
+type ObjectContents struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag,omitempty"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type CompleteMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []CompletedPart `xml:"Part"`
+}
+
+type CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+type CopyPartResult struct {
+	XMLName      xml.Name  `xml:"CopyPartResult"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+type ListPartsResult struct {
+	XMLName              xml.Name `xml:"ListPartsResult"`
+	Bucket               string   `xml:"Bucket"`
+	Key                  string   `xml:"Key"`
+	UploadID             string   `xml:"UploadId"`
+	PartNumberMarker     int      `xml:"PartNumberMarker"`
+	NextPartNumberMarker int      `xml:"NextPartNumberMarker"`
+	MaxParts             int      `xml:"MaxParts"`
+	IsTruncated          bool     `xml:"IsTruncated"`
+	Parts                []Part   `xml:"Part"`
+}
+
+type Part struct {
+	PartNumber   int       `xml:"PartNumber"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
 }
 
 type Error struct {
@@ -44,3 +312,23 @@ type Error struct {
 	Code    string   `xml:"Code"`
 	Message string   `xml:"Message"`
 }
+
+type AccessControlPolicy struct {
+	XMLName xml.Name `xml:"AccessControlPolicy"`
+	Owner   Owner    `xml:"Owner"`
+	Grants  []Grant  `xml:"AccessControlList>Grant"`
+}
+
+type Grant struct {
+	Grantee    Grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+// Grantee is either a user, identified by ID, or a group of users,
+// identified by URI.
+type Grantee struct {
+	XMLNSXSI string `xml:"xmlns:xsi,attr"`
+	Type     string `xml:"xsi:type,attr"`
+	ID       string `xml:"ID,omitempty"`
+	URI      string `xml:"URI,omitempty"`
+}
@@ -44,17 +44,19 @@ func validateExistingDir(dir string) error {
 		return nil
 	}
 
-	hasBucketsCSV := false
+	// A data directory has a metadata store, or the buckets.csv file of
+	// versions that predate it.
+	isDataDir := false
 
 	for _, entry := range entries {
 		name := entry.Name()
-		if name == "buckets.csv" {
-			hasBucketsCSV = true
+		if name == ".metadata" || name == "buckets.csv" {
+			isDataDir = true
 			break
 		}
 	}
 
-	if !hasBucketsCSV {
+	if !isDataDir {
 		return errors.New("directory can not be used as data directory")
 	}
 
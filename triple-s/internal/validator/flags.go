@@ -1,30 +1,105 @@
 package validator
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"time"
 )
 
-func InitFlags() (string, string, bool) {
-	port, dir, help := "", "", false
+type Config struct {
+	Port          string
+	Dir           string
+	Credentials   string
+	AdminKey      string
+	Dedup         bool
+	EncryptionKey string
+	AccessLog     string
+	Help          bool
+}
+
+func InitFlags() Config {
+	config := Config{}
 
-	flag.StringVar(&port, "port", "8080", "Port number")
-	flag.StringVar(&dir, "dir", "./data", "Path to directory")
-	flag.BoolVar(&help, "help", false, "Show help")
+	flag.StringVar(&config.Port, "port", "8080", "Port number")
+	flag.StringVar(&config.Dir, "dir", "./data", "Path to directory")
+	flag.StringVar(&config.Credentials, "credentials", "", "Path to the access key file")
+	flag.StringVar(&config.AdminKey, "admin-key", "", "Access key that becomes the owner of buckets without one")
+	flag.BoolVar(&config.Dedup, "dedup", false, "Store identical object data once")
+	flag.StringVar(&config.EncryptionKey, "encryption-key", "", "Path to the master key file for encryption at rest")
+	flag.StringVar(&config.AccessLog, "access-log", "-", "Path to the access log file, - for standard output")
+	flag.BoolVar(&config.Help, "help", false, "Show help")
 	flag.Parse()
 
-	return port, dir, help
+	return config
 }
 
 func PrintUsage() {
 	fmt.Println(`Simple Storage Service.
 
 **Usage:**
-    triple-s [-port <N>] [-dir <S>]  
+    triple-s [-port <N>] [-dir <S>] [-credentials <S> [-admin-key <S>]] [-dedup] [-encryption-key <S>] [-access-log <S>]
+    triple-s presign -credentials <S> -bucket <S> [-key <S>] [-method <S>] [-expires <D>]
     triple-s --help
 
 **Options:**
-- --help     Show this screen.
-- --port N   Port number
-- --dir S    Path to the directory`)
+- --help           Show this screen.
+- --port N         Port number
+- --dir S          Path to the directory
+- --credentials S  Path to a CSV file of AccessKeyId,SecretAccessKey pairs.
+                   Requests must be signed with AWS Signature V4 when set.
+- --admin-key S    Access key from the credentials file that becomes the
+                   owner of buckets without one, such as those created
+                   before --credentials was used.
+- --dedup          Store objects with identical content only once.
+                   Cannot be combined with --encryption-key.
+- --encryption-key S
+                   Path to the master key file. New objects are encrypted
+                   at rest with AES-256-GCM; a key is generated if missing.
+                   Encrypted objects are not deduplicated.
+- --access-log S   Path to the access log, one JSON line per request
+                   (default: - for standard output, empty to disable).
+
+**Presign options:**
+- --access-key S   Access key to sign with (default: first key in the file)
+- --endpoint S     Server URL (default: http://localhost:8080)
+- --region S       Signing region (default: us-east-1)
+- --method S       HTTP method the URL is valid for (default: GET)
+- --expires D      Validity, e.g. 15m or 24h, at most 168h (default: 1h)`)
+}
+
+type PresignConfig struct {
+	Credentials string
+	AccessKey   string
+	Endpoint    string
+	Region      string
+	Method      string
+	Bucket      string
+	Key         string
+	Expires     time.Duration
+}
+
+// InitPresignFlags parses the arguments of the presign subcommand.
+func InitPresignFlags(args []string) (PresignConfig, error) {
+	config := PresignConfig{}
+
+	fs := flag.NewFlagSet("presign", flag.ContinueOnError)
+	fs.StringVar(&config.Credentials, "credentials", "", "Path to the access key file")
+	fs.StringVar(&config.AccessKey, "access-key", "", "Access key to sign with, defaults to the first one in the file")
+	fs.StringVar(&config.Endpoint, "endpoint", "http://localhost:8080", "Server URL")
+	fs.StringVar(&config.Region, "region", "us-east-1", "Region of the signing scope")
+	fs.StringVar(&config.Method, "method", "GET", "HTTP method the URL is valid for")
+	fs.StringVar(&config.Bucket, "bucket", "", "Bucket name")
+	fs.StringVar(&config.Key, "key", "", "Object key")
+	fs.DurationVar(&config.Expires, "expires", time.Hour, "How long the URL stays valid, at most 168h")
+	err := fs.Parse(args)
+	if err != nil {
+		return config, err
+	}
+
+	if config.Credentials == "" || config.Bucket == "" {
+		return config, errors.New("-credentials and -bucket are required")
+	}
+
+	return config, nil
 }
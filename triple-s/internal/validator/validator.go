@@ -4,8 +4,12 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
+// reservedBucketNames are the paths of the health and metrics endpoints.
+var reservedBucketNames = map[string]bool{"healthz": true, "readyz": true, "metrics": true}
+
 func ValidateBucketName(name string) error {
 	if len(name) < 3 || len(name) > 63 {
 		return errors.New("bucket name must be between 3 and 63 characters long")
@@ -18,6 +22,33 @@ func ValidateBucketName(name string) error {
 	if strings.Contains(name, "..") || strings.Contains(name, "--") || strings.Contains(name, "-.") || strings.Contains(name, "-.") {
 		return errors.New("bucket name cannot contain consecutive special characters")
 	}
+	if reservedBucketNames[name] {
+		return errors.New("bucket name is reserved for the server's own endpoints")
+	}
+
+	return nil
+}
+
+// MaxObjectKeyLength is the longest object key S3 accepts, in bytes.
+const MaxObjectKeyLength = 1024
 
+var ErrObjectKeyTooLong = errors.New("object key is longer than 1024 bytes")
+
+// ValidateObjectKey checks that a key can be stored. Any UTF-8 string is a
+// valid key except that NUL bytes, which delimit keys in the metadata
+// index, are not allowed.
+func ValidateObjectKey(key string) error {
+	if key == "" {
+		return errors.New("object key must not be empty")
+	}
+	if len(key) > MaxObjectKeyLength {
+		return ErrObjectKeyTooLong
+	}
+	if !utf8.ValidString(key) {
+		return errors.New("object key must be valid UTF-8")
+	}
+	if strings.ContainsRune(key, 0) {
+		return errors.New("object key must not contain NUL characters")
+	}
 	return nil
 }
@@ -1,24 +1,55 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"triple-s/internal/auth"
+	"triple-s/internal/notify"
 	"triple-s/internal/router"
+	"triple-s/internal/storage"
 	"triple-s/internal/structure"
 	v "triple-s/internal/validator"
 )
 
+// staleUploadAge is how long an incomplete multipart upload is kept before
+// it is aborted.
+const staleUploadAge = 7 * 24 * time.Hour
+
+// lifecycleInterval is how often bucket lifecycle rules are enforced.
+const lifecycleInterval = time.Hour
+
+// shutdownTimeout bounds how long active requests may take to finish once
+// the server is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	port, dir, help := v.InitFlags()
+	if len(os.Args) > 1 && os.Args[1] == "presign" {
+		presign(os.Args[2:])
+		return
+	}
 
-	if help {
+	config := v.InitFlags()
+	port, dir := config.Port, config.Dir
+
+	if config.Help {
 		v.PrintUsage()
 		return
 	}
 
+	// Every new object is encrypted with its own data key when a master
+	// key is set, and encrypted objects are never deduplicated.
+	if config.Dedup && config.EncryptionKey != "" {
+		log.Fatal("-dedup cannot be combined with -encryption-key: encrypted objects are not deduplicated")
+	}
+
 	err := v.ValidateDataDirectory(dir)
 	if err != nil {
 		log.Fatalf("Invalid data directory: %v", err)
@@ -29,16 +60,104 @@ func main() {
 		log.Fatalf("Failed to create directory %s: %v", dir, err)
 	}
 
+	err = storage.Open(dir)
+	if err != nil {
+		log.Fatalf("Failed to open metadata store: %v", err)
+	}
+	if config.Dedup {
+		storage.EnableDeduplication(dir)
+	}
+	if config.EncryptionKey != "" {
+		err = storage.EnableEncryption(dir, config.EncryptionKey)
+		if err != nil {
+			log.Fatalf("Failed to load encryption key: %v", err)
+		}
+	}
+
 	server := structure.Server{
 		Dir:  dir,
 		Port: port,
 	}
 
-	mux := router.Router(&server)
+	if config.AccessLog == "-" {
+		server.AccessLog = os.Stdout
+	} else if config.AccessLog != "" {
+		server.AccessLog, err = os.OpenFile(config.AccessLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+	}
+
+	if config.Credentials != "" {
+		server.Credentials, err = auth.LoadCredentials(config.Credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+		claimOwnerlessBuckets(dir, config.AdminKey, server.Credentials)
+		server.AdminKey = config.AdminKey
+	} else {
+		if config.AdminKey != "" {
+			log.Fatal("-admin-key requires -credentials")
+		}
+		log.Println("Warning: no -credentials given, requests are not authenticated")
+	}
+
+	storage.StartUploadCleanup(dir, time.Hour, staleUploadAge)
+	storage.StartLifecycleWorker(dir, lifecycleInterval)
+	notify.StartWorker(dir)
+
+	handler := router.Router(&server)
+
+	httpServer := &http.Server{Addr: ":" + port, Handler: handler}
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		log.Println("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := httpServer.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Failed to finish active requests: %v", err)
+		}
+		close(stopped)
+	}()
 
 	fmt.Printf("Starting server on port %s, directory %s\n", port, dir)
-	err = http.ListenAndServe(":"+port, mux)
-	if err != nil {
+	err = httpServer.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	<-stopped
+
+	// Closing the metadata store folds its log into the snapshot, so the
+	// next start does not have to replay it.
+	err = storage.Close(dir)
+	if err != nil {
+		log.Fatalf("Failed to close metadata store: %v", err)
+	}
+}
+
+// claimOwnerlessBuckets gives the buckets that have no owner, because they
+// were created without credentials, to adminKey. Without an admin key they
+// are left ownerless, so only their policy and ACLs grant access to them.
+func claimOwnerlessBuckets(dir, adminKey string, credentials map[string]string) {
+	if _, ok := credentials[adminKey]; adminKey != "" && !ok {
+		log.Fatalf("Admin key %s is not in the credentials file", adminKey)
+	}
+
+	buckets, err := storage.ClaimOwnerlessBuckets(dir, adminKey)
+	if err != nil {
+		log.Fatalf("Failed to assign bucket owners: %v", err)
+	}
+	if len(buckets) == 0 {
+		return
+	}
+	if adminKey == "" {
+		log.Printf("Warning: %d buckets have no owner and are only reachable through their policy and ACLs; use -admin-key to assign one", len(buckets))
+		return
+	}
+	log.Printf("Assigned %d buckets without an owner to %s", len(buckets), adminKey)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"triple-s/internal/auth"
+	v "triple-s/internal/validator"
+)
+
+// presign prints a presigned URL for the bucket and key given on the
+// command line.
+func presign(args []string) {
+	config, err := v.InitPresignFlags(args)
+	if err != nil {
+		log.Fatalf("Invalid presign arguments: %v", err)
+	}
+
+	credentials, err := auth.LoadCredentials(config.Credentials)
+	if err != nil {
+		log.Fatalf("Failed to load credentials: %v", err)
+	}
+
+	accessKey := config.AccessKey
+	if accessKey == "" {
+		keys := make([]string, 0, len(credentials))
+		for key := range credentials {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		accessKey = keys[0]
+	}
+	secret, ok := credentials[accessKey]
+	if !ok {
+		log.Fatalf("Access key %s is not in %s", accessKey, config.Credentials)
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		log.Fatalf("Invalid endpoint %q", config.Endpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + config.Bucket
+	if config.Key != "" {
+		endpoint.Path += "/" + config.Key
+	}
+
+	presigned, err := auth.Presign(config.Method, endpoint.String(), accessKey, secret, config.Region, time.Now(), config.Expires)
+	if err != nil {
+		log.Fatalf("Failed to presign URL: %v", err)
+	}
+
+	fmt.Println(presigned)
+}